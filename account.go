@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cloudflare Warp peer public key, used when an imported account only
+// provides the private key.
+const warpPeerPublicKey = "bmXOC+F1FxEMF9dyiK2H5/1SUtzH0JuVo51h2wPfgyo="
+
+var accountPath = filepath.Join(CORE_DIR, "account.json")
+
+type storedAccount struct {
+	WarpParams
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func validateAccountFlags() error {
+	switch *accountFlag {
+	case "reuse", "rotate":
+	default:
+		return fmt.Errorf("invalid -account %q, please use reuse or rotate", *accountFlag)
+	}
+
+	if *importConfFlag != "" && *importKeyFlag != "" {
+		return fmt.Errorf("-import-conf and -import-key can not be used together")
+	}
+
+	if *importKeyFlag == "" && (*importReservedFlag != "" || *importIPv6Flag != "") {
+		return fmt.Errorf("-import-reserved and -import-ipv6 require -import-key")
+	}
+
+	return nil
+}
+
+func loadAccount() (WarpParams, error) {
+	data, err := os.ReadFile(accountPath)
+	if err != nil {
+		return WarpParams{}, err
+	}
+
+	var account storedAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return WarpParams{}, fmt.Errorf("error parsing %s: %w", accountPath, err)
+	}
+
+	if account.PrivateKey == "" || account.PublicKey == "" {
+		return WarpParams{}, fmt.Errorf("stored account in %s is incomplete", accountPath)
+	}
+
+	return account.WarpParams, nil
+}
+
+func saveAccount(params WarpParams) error {
+	account := storedAccount{
+		WarpParams: params,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	jsonBytes, err := json.MarshalIndent(account, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+
+	if err := os.WriteFile(accountPath, jsonBytes, 0600); err != nil {
+		return fmt.Errorf("error saving Warp account: %w", err)
+	}
+
+	return nil
+}
+
+// parseReserved accepts reserved bytes either as comma separated decimals
+// like 12,34,56 or as the base64 client_id returned by the Warp API.
+func parseReserved(value string) ([]int, error) {
+	value = strings.Trim(strings.TrimSpace(value), "[]")
+	if !strings.Contains(value, ",") {
+		return base64ToDecimal(value)
+	}
+
+	var reserved []int
+	for _, part := range strings.Split(value, ",") {
+		isValid, b := checkNum(strings.TrimSpace(part), 0, 255)
+		if !isValid {
+			return nil, fmt.Errorf("invalid reserved byte %q", part)
+		}
+		reserved = append(reserved, b)
+	}
+
+	if len(reserved) != 3 {
+		return nil, fmt.Errorf("reserved should be 3 bytes, got %d", len(reserved))
+	}
+
+	return reserved, nil
+}
+
+func importKey(privateKey, reserved, ipv6 string) (WarpParams, error) {
+	if !isValidBase64(privateKey) {
+		return WarpParams{}, fmt.Errorf("invalid private key, it should be base64 encoded")
+	}
+
+	params := WarpParams{
+		PublicKey:  warpPeerPublicKey,
+		PrivateKey: privateKey,
+	}
+
+	if reserved != "" {
+		bytes, err := parseReserved(reserved)
+		if err != nil {
+			return WarpParams{}, err
+		}
+		params.Reserved = bytes
+	}
+
+	if ipv6 != "" {
+		prefix, err := parseInterfaceAddress(ipv6)
+		if err != nil || !prefix.Addr().Is6() {
+			return WarpParams{}, fmt.Errorf("invalid IPv6 address %q", ipv6)
+		}
+		params.IPv6 = prefix.String()
+	}
+
+	return params, nil
+}
+
+func parseInterfaceAddress(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		return netip.ParsePrefix(value)
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// importWgConf reads a wg-quick config. Reserved bytes are read from a
+// Reserved key or a "# Reserved = ..." comment in any section.
+func importWgConf(path string) (WarpParams, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return WarpParams{}, fmt.Errorf("error reading WireGuard config: %w", err)
+	}
+	defer file.Close()
+
+	var params WarpParams
+	var section string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+
+		comment := strings.HasPrefix(line, "#")
+		key, value, found := strings.Cut(strings.TrimLeft(line, "# "), "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if comment && key != "reserved" {
+			continue
+		}
+
+		switch {
+		case key == "reserved":
+			reserved, err := parseReserved(value)
+			if err != nil {
+				return WarpParams{}, err
+			}
+			params.Reserved = reserved
+		case section == "interface" && key == "privatekey":
+			params.PrivateKey = value
+		case section == "interface" && key == "address":
+			for _, address := range strings.Split(value, ",") {
+				prefix, err := parseInterfaceAddress(strings.TrimSpace(address))
+				if err != nil {
+					return WarpParams{}, fmt.Errorf("invalid interface address %q", address)
+				}
+				if prefix.Addr().Is4() {
+					params.IPv4 = prefix.String()
+				} else {
+					params.IPv6 = prefix.String()
+				}
+			}
+		case section == "peer" && key == "publickey":
+			params.PublicKey = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return WarpParams{}, fmt.Errorf("error reading WireGuard config: %w", err)
+	}
+
+	if params.PrivateKey == "" {
+		return WarpParams{}, fmt.Errorf("no PrivateKey found in %s", path)
+	}
+
+	if params.PublicKey == "" {
+		params.PublicKey = warpPeerPublicKey
+	}
+
+	return params, nil
+}
+
+// getWarpParams returns an imported or stored Warp account, and registers a
+// new one only when none is available or a rotation is requested. A Warp+
+// license given by -license is applied before the account is returned.
+func getWarpParams() (WarpParams, error) {
+	params, err := resolveAccount()
+	if err != nil {
+		return WarpParams{}, err
+	}
+
+	if *licenseFlag != "" && *licenseFlag != params.License {
+		params, err = applyWarpLicense(params, *licenseFlag)
+		if err != nil {
+			return WarpParams{}, err
+		}
+
+		if err := saveAccount(params); err != nil {
+			failMessage(err.Error())
+		}
+		successMessage("Applied Warp+ license.")
+	}
+
+	if params.AccountType != "" {
+		message := fmt.Sprintf("Warp account type: %s, quota: %.2f GB\n", params.AccountType, float64(params.Quota)/1e9)
+		successMessage(message)
+	}
+
+	return params, nil
+}
+
+func resolveAccount() (WarpParams, error) {
+	var params WarpParams
+	var err error
+
+	switch {
+	case *importConfFlag != "":
+		params, err = importWgConf(*importConfFlag)
+	case *importKeyFlag != "":
+		params, err = importKey(*importKeyFlag, *importReservedFlag, *importIPv6Flag)
+	case *accountFlag == "reuse":
+		params, err = loadAccount()
+		if err == nil {
+			successMessage("Using stored Warp account.\n")
+			return params, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			failMessage(fmt.Sprintf("Ignoring stored Warp account: %v", err))
+		}
+		return registerAccount()
+	default:
+		return registerAccount()
+	}
+
+	if err != nil {
+		return WarpParams{}, err
+	}
+
+	if err := saveAccount(params); err != nil {
+		return WarpParams{}, err
+	}
+	successMessage("Imported Warp account.\n")
+
+	return params, nil
+}
+
+func registerAccount() (WarpParams, error) {
+	params, err := registerWarpAccount()
+	if err != nil {
+		return WarpParams{}, err
+	}
+
+	if err := saveAccount(params); err != nil {
+		failMessage(err.Error())
+	}
+
+	return params, nil
+}
+
+func formatReserved(reserved []int) string {
+	parts := make([]string, 0, len(reserved))
+	for _, b := range reserved {
+		parts = append(parts, strconv.Itoa(b))
+	}
+
+	return strings.Join(parts, ",")
+}
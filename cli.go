@@ -0,0 +1,423 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const exitUsage = 2
+
+var (
+	countFlag       = flag.Int("count", 100, "Number of endpoints to scan (1-10000)")
+	ipv4Flag        = flag.Bool("ipv4", false, "Scan IPv4 endpoints")
+	ipv6Flag        = flag.Bool("ipv6", false, "Scan IPv6 endpoints")
+	noiseFlag       = flag.String("noise", "default", "UDP noise mode: off, default or custom")
+	noiseTypeFlag   = flag.String("noise-type", "", "Custom noise type: base64, hex, str or rand")
+	noisePacketFlag = flag.String("noise-packet", "", "Custom noise packet, must match the noise type")
+	noiseDelayFlag  = flag.String("noise-delay", "", "Custom noise delay in milliseconds, fixed or interval like 1-5")
+	noiseCountFlag  = flag.Int("noise-count", 5, "Custom number of noise packets (1-50)")
+	topFlag         = flag.Int("top", 10, "Number of endpoints to show in results")
+	cidrFlag        = flag.String("cidr", "", "Comma separated IPv4/IPv6 CIDRs to pick endpoints from")
+	portsFlag       = flag.String("ports", "", "Comma separated ports or port ranges like 854,2400-2410")
+	endpointsFlag   = flag.String("endpoints-file", "", "Scan endpoints from a file, one ip:port per line")
+	exhaustiveFlag  = flag.Bool("exhaustive", false, "Scan every ip:port combination of the CIDRs and ports exactly once, ignores -count")
+	seedFlag        = flag.Int64("seed", 0, "Random seed to reproduce the endpoint list, shuffles the exhaustive order too")
+	batchSizeFlag   = flag.Int("batch-size", 500, "Endpoints scanned per Xray process (1-5000)")
+	concurrencyFlag = flag.Int("concurrency", 50, "Number of endpoints probed at the same time (1-1000)")
+	rateFlag        = flag.Int("rate", 30, "Maximum probes per second across all endpoints (0-10000), 0 for no limit")
+	throughputFlag  = flag.Int("throughput", 0, "Test download and upload speed of the top N endpoints and re-rank them, 0 to skip")
+	throughputURL   = flag.String("throughput-url", defaultDownloadURL, "URL downloaded through each tunnel by the throughput test")
+	uploadURL       = flag.String("upload-url", defaultUploadURL, "URL uploaded to by the throughput test, empty to skip uploads")
+	targetFlags     = targetListFlag("target", `Probe target like "GET https://example.com/ 200 ok", only the URL is required, repeat for more targets`)
+	targetModeFlag  = flag.String("target-mode", "round-robin", "How attempts use the targets: round-robin or all (every target must pass)")
+	sortFlag        = flag.String("sort", "score", "Rank endpoints by score, latency, loss, min, max, median, p90, stddev or jitter")
+	weightsFlag     = flag.String("weights", "", "Score weights like loss=0.5,latency=0.25,jitter=0.1,throughput=0.15, missing ones keep their default")
+	maxLossFlag     = flag.Float64("max-loss", 100, "Leave out endpoints with a higher loss rate in percent")
+	maxLatencyFlag  = flag.Int64("max-latency", 0, "Leave out endpoints with a higher average latency in ms, 0 for no limit")
+	roundsFlag      = flag.String("rounds", "", "Scan in tournament rounds with these attempts each, like 1,3,10, instead of a single pass")
+	survivorsFlag   = flag.Int("survivors", 25, "Percent of endpoints that go on to the next tournament round (1-100)")
+	engineFlag      = flag.String("engine", "xray", "Probe engine: xray for HTTP probes through Xray core, native for WireGuard handshakes without Xray")
+	configFlag      = flag.String("config", "", "Load scan options from a JSON profile, other flags override it")
+	formatFlag      = flag.String("format", "csv", "Extra result format next to result.csv: csv, json or jsonl")
+	exportFlag      = flag.String("export", "", "Export best endpoints as configs, comma separated: wg, xray, singbox, bpb")
+	exportCountFlag = flag.Int("export-count", 0, "Number of best endpoints to export (default same as -top)")
+
+	accountFlag        = flag.String("account", "reuse", "Warp account: reuse the stored one or rotate to a newly registered one")
+	importConfFlag     = flag.String("import-conf", "", "Import a Warp account from a WireGuard .conf file")
+	importKeyFlag      = flag.String("import-key", "", "Import a Warp account from a base64 private key")
+	importReservedFlag = flag.String("import-reserved", "", "Reserved bytes for -import-key, like 12,34,56 or base64 client_id")
+	importIPv6Flag     = flag.String("import-ipv6", "", "Interface IPv6 address for -import-key")
+	licenseFlag        = flag.String("license", "", "Apply a Warp+ license key to the Warp account")
+
+	xrayTimeoutFlag = flag.Duration("xray-timeout", 30*time.Second, "Maximum time to wait for Xray core to start listening")
+	debugFlag       = flag.Bool("debug", false, "Run Xray with debug logging and keep the logs of this run in core/log/<timestamp>")
+
+	intervalFlag     = flag.Duration("interval", time.Minute, "Monitor: time between probe cycles")
+	windowFlag       = flag.Int("window", 10, "Monitor: number of recent cycles the rolling statistics cover (1-1000)")
+	alertLossFlag    = flag.Float64("alert-loss", 20, "Monitor: alert when the rolling loss rate of an endpoint goes above this percent")
+	alertLatencyFlag = flag.Int64("alert-latency", 0, "Monitor: alert when the rolling average latency of an endpoint goes above this many ms, 0 to disable")
+	webhookFlag      = flag.String("webhook", "", "Monitor: URL that every event is POSTed to as JSON")
+	discoverFlag     = flag.Int("discover", 0, "Monitor: endpoints probed by a background scan every cycle to find better ones, 0 to disable")
+
+	listenFlag = flag.String("listen", "127.0.0.1:8086", "Serve: loopback address the scan API and /metrics listen on, monitor: serve /metrics on this address when given")
+)
+
+// command is the subcommand given before the flags, empty for a scan.
+var command string
+
+var selectedExports []string
+
+// scanFlags lists the flags that configure a scan. Setting any of them
+// switches the scanner to non-interactive mode.
+var scanFlags = map[string]bool{
+	"count":          true,
+	"ipv4":           true,
+	"ipv6":           true,
+	"noise":          true,
+	"noise-type":     true,
+	"noise-packet":   true,
+	"noise-delay":    true,
+	"noise-count":    true,
+	"top":            true,
+	"config":         true,
+	"cidr":           true,
+	"ports":          true,
+	"endpoints-file": true,
+	"exhaustive":     true,
+	"seed":           true,
+	"batch-size":     true,
+	"concurrency":    true,
+	"rate":           true,
+	"engine":         true,
+	"target":         true,
+	"target-mode":    true,
+	"sort":           true,
+	"weights":        true,
+	"max-loss":       true,
+	"max-latency":    true,
+	"rounds":         true,
+	"survivors":      true,
+	"throughput":     true,
+	"throughput-url": true,
+	"upload-url":     true,
+}
+
+var nonInteractive bool
+
+func targetListFlag(name, usage string) *targetList {
+	targets := &targetList{}
+	flag.Var(targets, name, usage)
+	return targets
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+func detectNonInteractive() {
+	flag.Visit(func(f *flag.Flag) {
+		if scanFlags[f.Name] {
+			nonInteractive = true
+		}
+	})
+}
+
+// applyFlags overrides the scan config, either defaults or a loaded
+// profile, with the flags that were explicitly set.
+func applyFlags() error {
+	if *configFlag != "" {
+		if err := loadProfile(*configFlag); err != nil {
+			return err
+		}
+	}
+
+	if isFlagSet("count") {
+		isValid, count := checkNum(strconv.Itoa(*countFlag), 1, 10000)
+		if !isValid {
+			return fmt.Errorf("invalid -count %d, please enter a numeric value between 1-10000", *countFlag)
+		}
+		scanConfig.EndpointCount = count
+	}
+
+	if isFlagSet("ipv4") || isFlagSet("ipv6") {
+		if !*ipv4Flag && !*ipv6Flag {
+			return fmt.Errorf("at least one of -ipv4 or -ipv6 must be enabled")
+		}
+		scanConfig.Ipv4Mode, scanConfig.Ipv6Mode = *ipv4Flag, *ipv6Flag
+	}
+
+	if isFlagSet("cidr") {
+		scanConfig.Cidrs = strings.Split(*cidrFlag, ",")
+	}
+
+	if isFlagSet("ports") {
+		ports, err := parsePorts(*portsFlag)
+		if err != nil {
+			return err
+		}
+		scanConfig.Ports = ports
+	}
+
+	if isFlagSet("endpoints-file") {
+		scanConfig.EndpointsFile = *endpointsFlag
+	}
+
+	if isFlagSet("exhaustive") {
+		scanConfig.Exhaustive = *exhaustiveFlag
+	}
+
+	if isFlagSet("seed") {
+		scanConfig.Seed = *seedFlag
+	}
+
+	if isFlagSet("batch-size") {
+		isValid, batchSize := checkNum(strconv.Itoa(*batchSizeFlag), 1, 5000)
+		if !isValid {
+			return fmt.Errorf("invalid -batch-size %d, please enter a numeric value between 1-5000", *batchSizeFlag)
+		}
+		scanConfig.BatchSize = batchSize
+	}
+
+	if isFlagSet("concurrency") {
+		isValid, concurrency := checkNum(strconv.Itoa(*concurrencyFlag), 1, 1000)
+		if !isValid {
+			return fmt.Errorf("invalid -concurrency %d, please enter a numeric value between 1-1000", *concurrencyFlag)
+		}
+		scanConfig.Concurrency = concurrency
+	}
+
+	if isFlagSet("rate") {
+		isValid, rate := checkNum(strconv.Itoa(*rateFlag), 0, 10000)
+		if !isValid {
+			return fmt.Errorf("invalid -rate %d, it should be between 0-10000, use 0 for no limit", *rateFlag)
+		}
+		scanConfig.ProbesPerSecond = rate
+	}
+
+	if isFlagSet("engine") {
+		if err := validateEngine(*engineFlag); err != nil {
+			return err
+		}
+		scanConfig.Engine = *engineFlag
+	}
+
+	if isFlagSet("target") {
+		scanConfig.Targets = nil
+		for _, spec := range *targetFlags {
+			target, err := parseTarget(spec)
+			if err != nil {
+				return err
+			}
+			scanConfig.Targets = append(scanConfig.Targets, target)
+		}
+	}
+
+	if isFlagSet("target-mode") {
+		scanConfig.TargetMode = *targetModeFlag
+	}
+
+	if err := validateTargets(scanConfig.Targets, scanConfig.TargetMode); err != nil {
+		return err
+	}
+
+	if isFlagSet("sort") {
+		if err := validateSortKey(*sortFlag); err != nil {
+			return err
+		}
+		scanConfig.SortBy = *sortFlag
+	}
+
+	if isFlagSet("weights") {
+		weights, err := parseWeights(*weightsFlag, scanConfig.Weights)
+		if err != nil {
+			return err
+		}
+		scanConfig.Weights = weights
+	}
+
+	if isFlagSet("max-loss") {
+		scanConfig.MaxLoss = *maxLossFlag
+	}
+
+	if isFlagSet("max-latency") {
+		scanConfig.MaxLatency = *maxLatencyFlag
+	}
+
+	if err := validateFilters(scanConfig.MaxLoss, scanConfig.MaxLatency); err != nil {
+		return err
+	}
+
+	if isFlagSet("rounds") {
+		rounds, err := parseRounds(*roundsFlag)
+		if err != nil {
+			return err
+		}
+		scanConfig.Rounds = rounds
+	}
+
+	if isFlagSet("survivors") {
+		scanConfig.SurvivorPercent = *survivorsFlag
+	}
+
+	if err := validateRounds(scanConfig.Rounds, scanConfig.SurvivorPercent); err != nil {
+		return err
+	}
+
+	if isFlagSet("throughput") {
+		if *throughputFlag < 0 {
+			return fmt.Errorf("invalid -throughput %d, it can not be negative, use 0 to skip the throughput test", *throughputFlag)
+		}
+		scanConfig.ThroughputCount = *throughputFlag
+	}
+
+	if isFlagSet("throughput-url") {
+		scanConfig.ThroughputURL = *throughputURL
+	}
+
+	if isFlagSet("upload-url") {
+		scanConfig.UploadURL = *uploadURL
+	}
+
+	if scanConfig.ThroughputCount > 0 {
+		if err := validateThroughputURL("-throughput-url", scanConfig.ThroughputURL, true); err != nil {
+			return err
+		}
+		if err := validateThroughputURL("-upload-url", scanConfig.UploadURL, false); err != nil {
+			return err
+		}
+	}
+
+	if err := validateEndpointSources(scanConfig); err != nil {
+		return err
+	}
+
+	if scanConfig.Exhaustive && scanConfig.EndpointsFile == "" {
+		source, _ := newEndpointSource(scanConfig)
+		scanConfig.EndpointCount = exhaustiveCount(source)
+	}
+
+	customNoise := isFlagSet("noise-type") || isFlagSet("noise-packet") ||
+		isFlagSet("noise-delay") || isFlagSet("noise-count")
+
+	switch *noiseFlag {
+	case "off", "default":
+		if customNoise {
+			return fmt.Errorf("-noise-type, -noise-packet, -noise-delay and -noise-count require -noise=custom")
+		}
+		if isFlagSet("noise") {
+			scanConfig.UseNoise = *noiseFlag == "default"
+			scanConfig.UdpNoise = defaultNoise
+		}
+	case "custom":
+		noise := Noise{
+			Type:   *noiseTypeFlag,
+			Packet: *noisePacketFlag,
+			Delay:  *noiseDelayFlag,
+			Count:  *noiseCountFlag,
+		}
+		if err := validateNoise(noise); err != nil {
+			return err
+		}
+		scanConfig.UseNoise = true
+		scanConfig.UdpNoise = noise
+	default:
+		return fmt.Errorf("invalid -noise %q, please use off, default or custom", *noiseFlag)
+	}
+
+	top := scanConfig.OutputCount
+	if isFlagSet("top") {
+		top = *topFlag
+	} else if top == 0 {
+		top = min(*topFlag, scanConfig.EndpointCount)
+	}
+	isValid, top := checkNum(strconv.Itoa(top), 1, scanConfig.EndpointCount)
+	if !isValid {
+		return fmt.Errorf("invalid -top, please enter a numeric value between 1-%d", scanConfig.EndpointCount)
+	}
+	scanConfig.OutputCount = top
+
+	return nil
+}
+
+func validateNoise(noise Noise) error {
+	switch noise.Type {
+	case "base64":
+		if !isValidBase64(noise.Packet) {
+			return fmt.Errorf("invalid noise packet for base64 type, please enter a valid Base64 value like aGVsbG8gd29ybGQ=")
+		}
+	case "hex":
+		if !isValidHex(noise.Packet) {
+			return fmt.Errorf("invalid noise packet for hex type, please enter a valid Hex value like 68656c6c6f20776f726c64")
+		}
+	case "str":
+		if noise.Packet == "" {
+			return fmt.Errorf("noise packet is required for str type")
+		}
+	case "rand":
+		if !isValidRange(noise.Packet) {
+			return fmt.Errorf("invalid noise packet for rand type, please enter a fixed length or an interval like 50-100")
+		}
+	default:
+		return fmt.Errorf("invalid noise type %q, please use base64, hex, str or rand", noise.Type)
+	}
+
+	if !isValidRange(noise.Delay) {
+		return fmt.Errorf("invalid noise delay %q, please enter a fixed number or an interval like 1-5", noise.Delay)
+	}
+
+	if isValid, _ := checkNum(strconv.Itoa(noise.Count), 1, 50); !isValid {
+		return fmt.Errorf("invalid noise count %d, please enter a numeric value between 1 and 50", noise.Count)
+	}
+
+	return nil
+}
+
+func validateEngine(engine string) error {
+	switch engine {
+	case "xray", "native":
+		return nil
+	default:
+		return fmt.Errorf("invalid engine %q, please use xray or native", engine)
+	}
+}
+
+func validateOutputFlags() error {
+	switch *formatFlag {
+	case "csv", "json", "jsonl":
+	default:
+		return fmt.Errorf("invalid -format %q, please use csv, json or jsonl", *formatFlag)
+	}
+
+	formats, err := parseExportFormats(*exportFlag)
+	if err != nil {
+		return err
+	}
+	selectedExports = formats
+
+	if *xrayTimeoutFlag <= 0 {
+		return fmt.Errorf("invalid -xray-timeout %s, it should be positive", *xrayTimeoutFlag)
+	}
+
+	if *exportCountFlag < 0 {
+		return fmt.Errorf("invalid -export-count %d, it can not be negative", *exportCountFlag)
+	}
+
+	return nil
+}
+
+func exitWithUsage(err error) {
+	failMessage(err.Error())
+	fmt.Fprintf(os.Stderr, "\nRun with -h to see all options.\n")
+	os.Exit(exitUsage)
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"time"
 )
 
@@ -18,7 +21,7 @@ type Dns struct {
 type Log struct {
 	Access   string `json:"access"`
 	Error    string `json:"error"`
-	Loglevel string `json:"warning"`
+	Loglevel string `json:"loglevel"`
 	DnsLog   bool   `json:"dnsLog,omitempty"`
 }
 
@@ -100,10 +103,10 @@ type XrayConfig struct {
 
 var xrayConfig = filepath.Join(CORE_DIR, "config.json")
 
-func buildHttpInbound(index int) httpInbound {
+func buildHttpInbound(index int, port int) httpInbound {
 	inbound := httpInbound{
 		Listen:   "127.0.0.1",
-		Port:     1080 + index,
+		Port:     port,
 		Protocol: "http",
 		Tag:      fmt.Sprintf("http-in-%d", index+1),
 	}
@@ -115,10 +118,7 @@ func buildWgOutbound(index int, endpoint string, warpConfig WarpParams) WgOutbou
 	outbound := WgOutbound{
 		Protocol: "wireguard",
 		Settings: Settings{
-			Address: []string{
-				"172.16.0.2/32",
-				warpConfig.IPv6,
-			},
+			Address:     warpConfig.addresses(),
 			Mtu:         1280,
 			NoKernelTun: true,
 			Peers: []Peers{
@@ -145,6 +145,21 @@ func buildWgOutbound(index int, endpoint string, warpConfig WarpParams) WgOutbou
 	return outbound
 }
 
+func buildNoiseOutbound() FreedomOutbound {
+	var noises []Noise
+	for range scanConfig.UdpNoise.Count {
+		noises = append(noises, scanConfig.UdpNoise)
+	}
+
+	return FreedomOutbound{
+		Protocol: "freedom",
+		Settings: FreedomSettings{
+			Noises: &noises,
+		},
+		Tag: "udp-noise",
+	}
+}
+
 func buildRoutingRule(index int) RoutingRule {
 	return RoutingRule{
 		InboundTag: []string{
@@ -155,7 +170,10 @@ func buildRoutingRule(index int) RoutingRule {
 	}
 }
 
-func buildConfig() (XrayConfig, error) {
+// buildConfig builds an Xray config for one batch of endpoints. Endpoint i
+// of the batch is served by an http inbound on ports[i], tags are numbered
+// from offset so they match the endpoint numbers of the whole scan.
+func buildConfig(endpoints []string, ports []int, offset int, warpConfig WarpParams) XrayConfig {
 	queryStrategy := "UseIP"
 	if scanConfig.Ipv4Mode && !scanConfig.Ipv6Mode {
 		queryStrategy = "UseIPv4"
@@ -167,9 +185,8 @@ func buildConfig() (XrayConfig, error) {
 	config := XrayConfig{
 		Remarks: "test",
 		Log: Log{
-			Access:   "core/log/access.log",
-			Error:    "core/log/error.log",
-			Loglevel: "warning",
+			Access:   xrayAccessLog(),
+			Loglevel: xrayLogLevel(),
 			// DnsLog:   true,
 		},
 		Dns: Dns{
@@ -200,28 +217,12 @@ func buildConfig() (XrayConfig, error) {
 	}
 
 	if scanConfig.UseNoise {
-		var noises []Noise
-		for range scanConfig.UdpNoise.Count {
-			noises = append(noises, scanConfig.UdpNoise)
-		}
-		udpNoiseOutbound := FreedomOutbound{
-			Protocol: "freedom",
-			Settings: FreedomSettings{
-				Noises: &noises,
-			},
-			Tag: "udp-noise",
-		}
-
-		config.Outbounds = append(config.Outbounds, udpNoiseOutbound)
-	}
-
-	warpConfig, err := getWarpParams()
-	if err != nil {
-		return XrayConfig{}, err
+		config.Outbounds = append(config.Outbounds, buildNoiseOutbound())
 	}
 
-	for index, endpoint := range scanConfig.Endpoints {
-		inbound := buildHttpInbound(index)
+	for i, endpoint := range endpoints {
+		index := offset + i
+		inbound := buildHttpInbound(index, ports[i])
 		config.Inbounds = append(config.Inbounds, inbound)
 
 		outbound := buildWgOutbound(index, endpoint, warpConfig)
@@ -231,15 +232,11 @@ func buildConfig() (XrayConfig, error) {
 		config.Routing.Rules = append(config.Routing.Rules, routingRule)
 	}
 
-	return config, nil
+	return config
 }
 
-func createXrayConfig() error {
-	config, err := buildConfig()
-	if err != nil {
-		return fmt.Errorf("error registering Warp account: %w", err)
-	}
-
+func createXrayConfig(endpoints []string, ports []int, offset int, warpConfig WarpParams) error {
+	config := buildConfig(endpoints, ports, offset, warpConfig)
 	jsonBytes, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return fmt.Errorf("json marshal error: %w", err)
@@ -258,13 +255,118 @@ func createXrayConfig() error {
 	return nil
 }
 
-func runXrayCore() (*exec.Cmd, error) {
+// allocatePorts reserves count free local TCP ports. All listeners stay open
+// until every port is picked, so the same port is never returned twice.
+func allocatePorts(count int) ([]int, error) {
+	listeners := make([]net.Listener, 0, count)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	ports := make([]int, 0, count)
+	for range count {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("error allocating local port: %w", err)
+		}
+		listeners = append(listeners, listener)
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	return ports, nil
+}
+
+type xrayProcess struct {
+	cmd    *exec.Cmd
+	output *xrayOutput
+	exited chan struct{}
+	err    error
+}
+
+func (p *xrayProcess) Stop() error {
+	if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("error killing Xray core: %w", err)
+	}
+
+	<-p.exited
+	return nil
+}
+
+// prepareXrayCore makes sure the Xray binary exists and is executable.
+func prepareXrayCore() error {
+	if _, err := os.Stat(xrayPath); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(xrayPath, 0755); err != nil {
+		return fmt.Errorf("error setting Xray core permissions: %w", err)
+	}
+
+	return nil
+}
+
+// runXrayCore starts Xray with its output piped into the scanner log and
+// waits until every inbound port accepts connections. It fails early with
+// Xray's output if the process exits or does not get ready within timeout.
+func runXrayCore(ports []int, batch int, timeout time.Duration) (*xrayProcess, error) {
+	output := newXrayOutput(batch)
 	cmd := exec.Command(xrayPath, "-c", xrayConfig)
+	cmd.Stdout = output
+	cmd.Stderr = output
 	if err := cmd.Start(); err != nil {
+		xrayStarts.Inc("failure")
 		return nil, fmt.Errorf("error starting XRay core: %v", err)
 	}
 
+	process := &xrayProcess{
+		cmd:    cmd,
+		output: output,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		process.err = cmd.Wait()
+		close(process.exited)
+	}()
+
 	fmt.Printf("%s Waiting for XRay core to initialize...\n\n", prompt)
-	time.Sleep(1000 * time.Millisecond)
-	return cmd, nil
+	if err := waitForInbounds(process, ports, timeout); err != nil {
+		xrayStarts.Inc("failure")
+		process.Stop()
+		return nil, err
+	}
+	xrayStarts.Inc("success")
+
+	return process, nil
+}
+
+func waitForInbounds(process *xrayProcess, ports []int, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	pending := slices.Clone(ports)
+	for {
+		pending = slices.DeleteFunc(pending, func(port int) bool {
+			conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 200*time.Millisecond)
+			if err != nil {
+				return false
+			}
+			conn.Close()
+			return true
+		})
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-process.exited:
+			return fmt.Errorf("XRay core exited during startup (%v): %s", process.err, process.output)
+		case <-deadline:
+			return fmt.Errorf("XRay core not ready after %s, %d of %d inbounds are not listening: %s",
+				timeout, len(pending), len(ports), process.output)
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEnumeratedEndpoints caps exhaustive mode, so a wide CIDR like an IPv6
+// /64 fails early instead of exhausting memory.
+const maxEnumeratedEndpoints = 1 << 20
+
+const resolveTimeout = 5 * time.Second
+
+// endpointSource holds the parsed sources endpoints are generated from.
+type endpointSource struct {
+	ipv4Prefixes []netip.Prefix
+	ipv6Prefixes []netip.Prefix
+	ports        []uint16
+}
+
+// parsePorts expands a port list like 854,859,2400-2410 and drops duplicates.
+func parsePorts(spec string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if !isValidRange(part) {
+			return nil, fmt.Errorf("invalid port or port range %q", part)
+		}
+
+		low, high, _ := strings.Cut(part, "-")
+		if high == "" {
+			high = low
+		}
+		isValidLow, from := checkNum(low, 1, 65535)
+		isValidHigh, to := checkNum(high, 1, 65535)
+		if !isValidLow || !isValidHigh {
+			return nil, fmt.Errorf("invalid port or port range %q, ports should be between 1-65535", part)
+		}
+
+		for port := from; port <= to; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+
+	return ports, nil
+}
+
+// parseCidrs parses IPv4 and IPv6 CIDRs of any prefix length, a bare IP is
+// treated as a single address range.
+func parseCidrs(cidrs []string) (ipv4 []netip.Prefix, ipv6 []netip.Prefix, err error) {
+	seen := make(map[netip.Prefix]bool)
+	for _, cidr := range cidrs {
+		prefix, err := parseInterfaceAddress(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+
+		prefix = prefix.Masked()
+		if seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+
+		if prefix.Addr().Is4() {
+			ipv4 = append(ipv4, prefix)
+		} else {
+			ipv6 = append(ipv6, prefix)
+		}
+	}
+
+	return ipv4, ipv6, nil
+}
+
+// resolveEndpoint parses an ip:port endpoint, or resolves a host:port one to
+// an endpoint for every address of the host.
+func resolveEndpoint(line string) ([]netip.AddrPort, error) {
+	if endpoint, err := netip.ParseAddrPort(line); err == nil {
+		if endpoint.Port() == 0 {
+			return nil, fmt.Errorf("port should be between 1-65535")
+		}
+		return []netip.AddrPort{netip.AddrPortFrom(endpoint.Addr().Unmap(), endpoint.Port())}, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(line)
+	if err != nil {
+		return nil, fmt.Errorf("expected ip:port or host:port")
+	}
+	isValid, port := checkNum(portStr, 1, 65535)
+	if !isValid {
+		return nil, fmt.Errorf("port should be between 1-65535")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %w", host, err)
+	}
+
+	endpoints := make([]netip.AddrPort, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, netip.AddrPortFrom(addr.Unmap(), uint16(port)))
+	}
+
+	return endpoints, nil
+}
+
+// readEndpointsFile reads one ip:port or host:port endpoint per line,
+// skipping blank lines, comments and duplicates. Hosts are resolved once, to
+// all of their addresses.
+func readEndpointsFile(path string) ([]netip.AddrPort, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading endpoints file: %w", err)
+	}
+	defer file.Close()
+
+	var endpoints []netip.AddrPort
+	seen := make(map[netip.AddrPort]bool)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		resolved, err := resolveEndpoint(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q on line %d of %s: %w", line, lineNum, path, err)
+		}
+
+		for _, endpoint := range resolved {
+			if !seen[endpoint] {
+				seen[endpoint] = true
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading endpoints file: %w", err)
+	}
+
+	return endpoints, nil
+}
+
+func newEndpointSource(config ScanConfig) (endpointSource, error) {
+	ipv4, ipv6, err := parseCidrs(config.Cidrs)
+	if err != nil {
+		return endpointSource{}, err
+	}
+
+	source := endpointSource{}
+	if config.Ipv4Mode {
+		source.ipv4Prefixes = ipv4
+	}
+	if config.Ipv6Mode {
+		source.ipv6Prefixes = ipv6
+	}
+
+	seen := make(map[uint16]bool)
+	for _, port := range config.Ports {
+		if isValid, _ := checkNum(strconv.Itoa(port), 1, 65535); !isValid {
+			return endpointSource{}, fmt.Errorf("invalid port %d", port)
+		}
+		if !seen[uint16(port)] {
+			seen[uint16(port)] = true
+			source.ports = append(source.ports, uint16(port))
+		}
+	}
+
+	return source, nil
+}
+
+func validateEndpointSources(config ScanConfig) error {
+	if config.EndpointsFile != "" {
+		if _, err := os.Stat(config.EndpointsFile); err != nil {
+			return fmt.Errorf("error reading endpoints file: %w", err)
+		}
+		return nil
+	}
+
+	source, err := newEndpointSource(config)
+	if err != nil {
+		return err
+	}
+
+	if len(source.ports) == 0 {
+		return fmt.Errorf("port list can not be empty")
+	}
+	if config.Ipv4Mode && len(source.ipv4Prefixes) == 0 {
+		return fmt.Errorf("no IPv4 CIDR given for IPv4 mode")
+	}
+	if config.Ipv6Mode && len(source.ipv6Prefixes) == 0 {
+		return fmt.Errorf("no IPv6 CIDR given for IPv6 mode")
+	}
+
+	if config.Exhaustive && exhaustiveCount(source) > maxEnumeratedEndpoints {
+		return fmt.Errorf("exhaustive mode supports up to %d endpoints, please use narrower CIDRs or fewer ports", maxEnumeratedEndpoints)
+	}
+
+	return nil
+}
+
+// exhaustiveCount returns the number of endpoints exhaustive mode scans,
+// capped just above maxEnumeratedEndpoints.
+func exhaustiveCount(source endpointSource) int {
+	prefixes := slices.Concat(source.ipv4Prefixes, source.ipv6Prefixes)
+	return spaceSize(prefixes, len(source.ports), maxEnumeratedEndpoints+1)
+}
+
+// prefixSize returns the number of addresses in a prefix.
+func prefixSize(prefix netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits()))
+}
+
+// spaceSize returns the number of ip:port combinations of the given
+// prefixes, capped to limit.
+func spaceSize(prefixes []netip.Prefix, ports int, limit int) int {
+	total := new(big.Int)
+	for _, prefix := range prefixes {
+		total.Add(total, prefixSize(prefix))
+	}
+	total.Mul(total, big.NewInt(int64(ports)))
+
+	if !total.IsInt64() || total.Int64() > int64(limit) {
+		return limit
+	}
+
+	return int(total.Int64())
+}
+
+func randomAddr(rng *rand.Rand, prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	for i := len(bytes) - 1; i >= 0 && hostBits > 0; i-- {
+		bits := min(8, hostBits)
+		bytes[i] |= byte(rng.Intn(256)) & byte(1<<bits-1)
+		hostBits -= bits
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// enumerateEndpoints lists every ip:port combination of the prefixes in a
+// deterministic order.
+func enumerateEndpoints(prefixes []netip.Prefix, ports []uint16) []string {
+	endpoints := make([]string, 0, spaceSize(prefixes, len(ports), maxEnumeratedEndpoints))
+	seen := make(map[string]bool)
+
+	for _, prefix := range prefixes {
+		for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+			for _, port := range ports {
+				endpoint := netip.AddrPortFrom(addr, port).String()
+				if !seen[endpoint] {
+					seen[endpoint] = true
+					endpoints = append(endpoints, endpoint)
+				}
+			}
+		}
+	}
+
+	return endpoints
+}
+
+// randomEndpoints picks count unique endpoints. When count gets close to the
+// number of possible combinations, it samples a shuffled enumeration instead
+// of rejecting duplicates.
+func randomEndpoints(rng *rand.Rand, prefixes []netip.Prefix, ports []uint16, count int) []string {
+	space := spaceSize(prefixes, len(ports), 2*count)
+	count = min(count, space)
+
+	if space < 2*count {
+		endpoints := enumerateEndpoints(prefixes, ports)
+		rng.Shuffle(len(endpoints), func(i, j int) {
+			endpoints[i], endpoints[j] = endpoints[j], endpoints[i]
+		})
+		return endpoints[:count]
+	}
+
+	endpoints := make([]string, 0, count)
+	seen := make(map[string]bool)
+
+	for len(endpoints) < count {
+		prefix := prefixes[rng.Intn(len(prefixes))]
+		port := ports[rng.Intn(len(ports))]
+		endpoint := netip.AddrPortFrom(randomAddr(rng, prefix), port).String()
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return endpoints
+}
+
+func generateEndpoints() error {
+	var endpoints []string
+
+	if scanConfig.EndpointsFile != "" {
+		fileEndpoints, err := readEndpointsFile(scanConfig.EndpointsFile)
+		if err != nil {
+			return err
+		}
+
+		for _, endpoint := range fileEndpoints {
+			if endpoint.Addr().Is4() && scanConfig.Ipv4Mode || endpoint.Addr().Is6() && scanConfig.Ipv6Mode {
+				endpoints = append(endpoints, endpoint.String())
+			}
+		}
+
+		if skipped := len(fileEndpoints) - len(endpoints); skipped > 0 {
+			failMessage(fmt.Sprintf("Skipped %d endpoints not matching the selected IP version.", skipped))
+		}
+	} else {
+		source, err := newEndpointSource(scanConfig)
+		if err != nil {
+			return err
+		}
+
+		seed := scanConfig.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		rng := rand.New(rand.NewSource(seed))
+
+		if scanConfig.Exhaustive {
+			endpoints = enumerateEndpoints(slices.Concat(source.ipv4Prefixes, source.ipv6Prefixes), source.ports)
+			if scanConfig.Seed != 0 {
+				rng.Shuffle(len(endpoints), func(i, j int) {
+					endpoints[i], endpoints[j] = endpoints[j], endpoints[i]
+				})
+			}
+			scanConfig.EndpointCount = len(endpoints)
+		} else {
+			fmt.Printf("\n%s Using random seed %d, pass it with -seed to reproduce this endpoint list.\n", prompt, seed)
+
+			ipv4Count, ipv6Count := 0, 0
+			if scanConfig.Ipv4Mode && scanConfig.Ipv6Mode {
+				ipv4Count = scanConfig.EndpointCount / 2
+				ipv6Count = scanConfig.EndpointCount - ipv4Count
+			} else if scanConfig.Ipv4Mode {
+				ipv4Count = scanConfig.EndpointCount
+			} else if scanConfig.Ipv6Mode {
+				ipv6Count = scanConfig.EndpointCount
+			}
+
+			if ipv4Count > 0 {
+				endpoints = append(endpoints, randomEndpoints(rng, source.ipv4Prefixes, source.ports, ipv4Count)...)
+			}
+			if ipv6Count > 0 {
+				endpoints = append(endpoints, randomEndpoints(rng, source.ipv6Prefixes, source.ports, ipv6Count)...)
+			}
+		}
+	}
+
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no endpoints to scan")
+	}
+
+	message := fmt.Sprintf("Generated %d endpoints to test", len(endpoints))
+	successMessage(message)
+	scanConfig.Endpoints = endpoints
+	return nil
+}
+
+func isIPv6Endpoint(endpoint string) bool {
+	addrPort, err := netip.ParseAddrPort(endpoint)
+	return err == nil && addrPort.Addr().Is6()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"math/rand"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{spec: "2408", want: []int{2408}},
+		{spec: "854,859,2400-2403", want: []int{854, 859, 2400, 2401, 2402, 2403}},
+		{spec: " 500 , 500,499-501", want: []int{500, 499, 501}},
+		{spec: "65535", want: []int{65535}},
+		{spec: "0", wantErr: true},
+		{spec: "65536", wantErr: true},
+		{spec: "10-", wantErr: true},
+		{spec: "abc", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePorts(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePorts(%q) error = %v, want error %t", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("parsePorts(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseCidrs(t *testing.T) {
+	tests := []struct {
+		cidrs    []string
+		wantIPv4 []string
+		wantIPv6 []string
+		wantErr  bool
+	}{
+		{
+			cidrs:    []string{"162.159.192.0/24", "188.114.97.9/23", "8.6.112.7/31", "1.1.1.1"},
+			wantIPv4: []string{"162.159.192.0/24", "188.114.96.0/23", "8.6.112.6/31", "1.1.1.1/32"},
+		},
+		{
+			cidrs:    []string{"2606:4700:d0::1/61", "2606:4700:d1::/127", "2606:4700::1"},
+			wantIPv6: []string{"2606:4700:d0::/61", "2606:4700:d1::/127", "2606:4700::1/128"},
+		},
+		{
+			cidrs:    []string{"10.0.0.0/30", " 10.0.0.3/30 ", "2001:db8::/126", "2001:db8::2/126"},
+			wantIPv4: []string{"10.0.0.0/30"},
+			wantIPv6: []string{"2001:db8::/126"},
+		},
+		{cidrs: []string{"300.1.1.0/24"}, wantErr: true},
+		{cidrs: []string{"10.0.0.0/33"}, wantErr: true},
+		{cidrs: []string{"2001:db8::/129"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		ipv4, ipv6, err := parseCidrs(tt.cidrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCidrs(%q) error = %v, want error %t", tt.cidrs, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := prefixStrings(ipv4); !slices.Equal(got, tt.wantIPv4) {
+			t.Errorf("parseCidrs(%q) IPv4 = %v, want %v", tt.cidrs, got, tt.wantIPv4)
+		}
+		if got := prefixStrings(ipv6); !slices.Equal(got, tt.wantIPv6) {
+			t.Errorf("parseCidrs(%q) IPv6 = %v, want %v", tt.cidrs, got, tt.wantIPv6)
+		}
+	}
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	var s []string
+	for _, prefix := range prefixes {
+		s = append(s, prefix.String())
+	}
+
+	return s
+}
+
+func mustPrefixes(t *testing.T, cidrs ...string) []netip.Prefix {
+	t.Helper()
+
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes
+}
+
+// allEndpoints lists every ip:port combination of the prefixes the slow
+// way, for checking what the scanner generates.
+func allEndpoints(prefixes []netip.Prefix, ports []uint16) map[string]bool {
+	all := make(map[string]bool)
+	for _, prefix := range prefixes {
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			for _, port := range ports {
+				all[netip.AddrPortFrom(addr, port).String()] = true
+			}
+		}
+	}
+
+	return all
+}
+
+// checkEndpoints fails unless endpoints are unique and all within the
+// prefixes and ports.
+func checkEndpoints(t *testing.T, endpoints []string, prefixes []netip.Prefix, ports []uint16) {
+	t.Helper()
+
+	seen := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		if seen[endpoint] {
+			t.Errorf("endpoint %s generated twice", endpoint)
+		}
+		seen[endpoint] = true
+
+		addrPort, err := netip.ParseAddrPort(endpoint)
+		if err != nil {
+			t.Errorf("endpoint %s: %v", endpoint, err)
+			continue
+		}
+		inPrefix := slices.ContainsFunc(prefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addrPort.Addr()) })
+		if !inPrefix || !slices.Contains(ports, addrPort.Port()) {
+			t.Errorf("endpoint %s is outside the CIDRs and ports", endpoint)
+		}
+	}
+}
+
+func TestEnumerateEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []netip.Prefix
+		ports    []uint16
+	}{
+		{"odd IPv4 prefixes", mustPrefixes(t, "10.0.0.0/30", "10.0.1.4/31", "10.0.2.9/32"), []uint16{854, 2408}},
+		{"overlapping prefixes", mustPrefixes(t, "10.0.0.0/29", "10.0.0.4/30"), []uint16{2408}},
+		{"IPv6", mustPrefixes(t, "2606:4700:d0::/126", "2606:4700:d1::/127"), []uint16{500, 854, 2408}},
+		{"last addresses", mustPrefixes(t, "255.255.255.252/30"), []uint16{1}},
+	}
+
+	for _, tt := range tests {
+		space := allEndpoints(tt.prefixes, tt.ports)
+		got := enumerateEndpoints(tt.prefixes, tt.ports)
+		if len(got) != len(space) {
+			t.Errorf("%s: got %d endpoints, want all %d", tt.name, len(got), len(space))
+		}
+		checkEndpoints(t, got, tt.prefixes, tt.ports)
+	}
+}
+
+func TestRandomEndpoints(t *testing.T) {
+	prefixes := mustPrefixes(t, "10.0.0.0/29", "2001:db8::/127")
+	ports := []uint16{854, 2408}
+	space := allEndpoints(prefixes, ports)
+
+	tests := []struct {
+		name  string
+		count int
+		want  int
+	}{
+		{"sparse", 3, 3},
+		{"near the full space", len(space) - 2, len(space) - 2},
+		{"full space", len(space), len(space)},
+		{"more than the space", len(space) + 10, len(space)},
+	}
+
+	for _, tt := range tests {
+		got := randomEndpoints(rand.New(rand.NewSource(1)), prefixes, ports, tt.count)
+		if len(got) != tt.want {
+			t.Errorf("%s: got %d endpoints, want %d", tt.name, len(got), tt.want)
+		}
+		checkEndpoints(t, got, prefixes, ports)
+	}
+
+	// Wide ranges take the rejection path.
+	wide := mustPrefixes(t, "10.0.0.0/8")
+	got := randomEndpoints(rand.New(rand.NewSource(1)), wide, ports, 1000)
+	if len(got) != 1000 {
+		t.Errorf("got %d endpoints of a /8, want 1000", len(got))
+	}
+	checkEndpoints(t, got, wide, ports)
+}
+
+func TestGenerateEndpointsSeed(t *testing.T) {
+	config := scanConfig
+	config.EndpointsFile = ""
+	config.Cidrs = []string{"10.0.0.0/24", "2001:db8::/120"}
+	config.Ports = []int{854, 2408}
+	config.Ipv4Mode = true
+	config.Ipv6Mode = true
+	config.EndpointCount = 50
+	setScanConfig(t, config)
+
+	generate := func(seed int64, exhaustive bool) []string {
+		t.Helper()
+
+		scanConfig.Seed = seed
+		scanConfig.Exhaustive = exhaustive
+		scanConfig.EndpointCount = 50
+		if err := generateEndpoints(); err != nil {
+			t.Fatalf("generateEndpoints: %v", err)
+		}
+		return scanConfig.Endpoints
+	}
+
+	for _, exhaustive := range []bool{false, true} {
+		first, second := generate(42, exhaustive), generate(42, exhaustive)
+		if !slices.Equal(first, second) {
+			t.Errorf("exhaustive %t: seed 42 gave different endpoint lists", exhaustive)
+		}
+		if other := generate(43, exhaustive); slices.Equal(first, other) {
+			t.Errorf("exhaustive %t: seeds 42 and 43 gave the same endpoint list", exhaustive)
+		}
+	}
+
+	if got := generate(42, true); len(got) != 2*(256+256) {
+		t.Errorf("exhaustive scan generated %d endpoints, want %d", len(got), 2*(256+256))
+	}
+}
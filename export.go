@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const EXPORT_DIR = "export"
+
+var exportFormats = []string{"wg", "xray", "singbox", "bpb"}
+
+type singBoxPeer struct {
+	Address             string   `json:"address"`
+	Port                int      `json:"port"`
+	PublicKey           string   `json:"public_key"`
+	AllowedIPs          []string `json:"allowed_ips"`
+	PersistentKeepalive int      `json:"persistent_keepalive_interval"`
+	Reserved            []int    `json:"reserved"`
+}
+
+type singBoxEndpoint struct {
+	Type       string        `json:"type"`
+	Tag        string        `json:"tag"`
+	System     bool          `json:"system"`
+	Mtu        int           `json:"mtu"`
+	Address    []string      `json:"address"`
+	PrivateKey string        `json:"private_key"`
+	Peers      []singBoxPeer `json:"peers"`
+}
+
+func parseExportFormats(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		format = strings.TrimSpace(format)
+		if !slices.Contains(exportFormats, format) {
+			return nil, fmt.Errorf("invalid export format %q, please use %s", format, strings.Join(exportFormats, ", "))
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+
+	return formats, nil
+}
+
+// buildWgQuickConfig renders a standard wg-quick config. wg-quick has no
+// reserved field, so reserved bytes are kept as a comment for clients that
+// support them.
+func buildWgQuickConfig(endpoint string, params WarpParams) string {
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", params.PrivateKey)
+	fmt.Fprintf(&b, "Address = %s\n", strings.Join(params.addresses(), ", "))
+	b.WriteString("DNS = 1.1.1.1, 1.0.0.1, 2606:4700:4700::1111, 2606:4700:4700::1001\n")
+	b.WriteString("MTU = 1280\n")
+	fmt.Fprintf(&b, "# Reserved = %s\n", formatReserved(params.Reserved))
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", params.PublicKey)
+	b.WriteString("AllowedIPs = 0.0.0.0/0, ::/0\n")
+	fmt.Fprintf(&b, "Endpoint = %s\n", endpoint)
+	b.WriteString("PersistentKeepalive = 25\n")
+
+	return b.String()
+}
+
+func buildXrayOutbounds(results []ScanResult, params WarpParams) []any {
+	outbounds := make([]any, 0, len(results)+1)
+	for i, r := range results {
+		outbounds = append(outbounds, buildWgOutbound(i, r.Endpoint, params))
+	}
+
+	if scanConfig.UseNoise {
+		outbounds = append(outbounds, buildNoiseOutbound())
+	}
+
+	return outbounds
+}
+
+func buildSingBoxEndpoints(results []ScanResult, params WarpParams) []singBoxEndpoint {
+	endpoints := make([]singBoxEndpoint, 0, len(results))
+	for i, r := range results {
+		endpoints = append(endpoints, singBoxEndpoint{
+			Type:       "wireguard",
+			Tag:        fmt.Sprintf("warp-%d", i+1),
+			Mtu:        1280,
+			Address:    params.addresses(),
+			PrivateKey: params.PrivateKey,
+			Peers: []singBoxPeer{
+				{
+					Address:             r.Host,
+					Port:                r.Port,
+					PublicKey:           params.PublicKey,
+					AllowedIPs:          []string{"0.0.0.0/0", "::/0"},
+					PersistentKeepalive: 5,
+					Reserved:            params.Reserved,
+				},
+			},
+		})
+	}
+
+	return endpoints
+}
+
+// buildBpbEndpoints returns the endpoints as a comma separated list, ready to
+// paste into BPB Panel Warp endpoints setting.
+func buildBpbEndpoints(results []ScanResult) string {
+	endpoints := make([]string, 0, len(results))
+	for _, r := range results {
+		endpoints = append(endpoints, r.Endpoint)
+	}
+
+	return strings.Join(endpoints, ",")
+}
+
+// writeJsonFile writes v as indented JSON. Exports holding the account
+// private key use mode 0600, like the wg-quick files.
+func writeJsonFile(path string, v any, perm os.FileMode) error {
+	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+
+	return os.WriteFile(path, jsonBytes, perm)
+}
+
+// exportEndpoints writes the requested configs for the given results into
+// EXPORT_DIR and returns the created file paths.
+func exportEndpoints(results []ScanResult, params WarpParams, formats []string) ([]string, error) {
+	if err := os.MkdirAll(EXPORT_DIR, 0755); err != nil {
+		return nil, fmt.Errorf("error creating export directory: %w", err)
+	}
+
+	var files []string
+	for _, format := range formats {
+		switch format {
+		case "wg":
+			for i, r := range results {
+				path := filepath.Join(EXPORT_DIR, fmt.Sprintf("warp-%d.conf", i+1))
+				if err := os.WriteFile(path, []byte(buildWgQuickConfig(r.Endpoint, params)), 0600); err != nil {
+					return files, fmt.Errorf("error writing %s: %w", path, err)
+				}
+				files = append(files, path)
+			}
+		case "xray":
+			path := filepath.Join(EXPORT_DIR, "xray-outbounds.json")
+			if err := writeJsonFile(path, buildXrayOutbounds(results, params), 0600); err != nil {
+				return files, fmt.Errorf("error writing %s: %w", path, err)
+			}
+			files = append(files, path)
+		case "singbox":
+			path := filepath.Join(EXPORT_DIR, "sing-box-endpoints.json")
+			config := map[string]any{"endpoints": buildSingBoxEndpoints(results, params)}
+			if err := writeJsonFile(path, config, 0600); err != nil {
+				return files, fmt.Errorf("error writing %s: %w", path, err)
+			}
+			files = append(files, path)
+		case "bpb":
+			path := filepath.Join(EXPORT_DIR, "bpb-endpoints.txt")
+			if err := os.WriteFile(path, []byte(buildBpbEndpoints(results)+"\n"), 0644); err != nil {
+				return files, fmt.Errorf("error writing %s: %w", path, err)
+			}
+			files = append(files, path)
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errFakeTimeout = errors.New("fake: no handshake response")
+
+// fakeProber replays scripted attempts per endpoint in order, so scheduling,
+// aggregation and ranking can be tested without Xray or network access.
+// Endpoints without attempts left time out. Every probe takes delay, and the
+// highest number of probes running at once is kept.
+type fakeProber struct {
+	mu          sync.Mutex
+	script      map[string][]Attempt
+	calls       map[string]int
+	delay       time.Duration
+	inFlight    int
+	maxInFlight int
+}
+
+func newFakeProber(script map[string][]Attempt) *fakeProber {
+	if script == nil {
+		script = make(map[string][]Attempt)
+	}
+
+	return &fakeProber{
+		script: script,
+		calls:  make(map[string]int),
+	}
+}
+
+func (p *fakeProber) Probe(ctx context.Context, endpoint string) Attempt {
+	p.mu.Lock()
+	attempt := Attempt{Err: errFakeTimeout}
+	if attempts := p.script[endpoint]; len(attempts) > 0 {
+		attempt = attempts[0]
+		p.script[endpoint] = attempts[1:]
+	}
+	p.calls[endpoint]++
+	p.inFlight++
+	p.maxInFlight = max(p.maxInFlight, p.inFlight)
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return Attempt{Err: ctx.Err()}
+	case <-time.After(p.delay):
+	}
+
+	return attempt
+}
+
+func (p *fakeProber) Close() error {
+	return nil
+}
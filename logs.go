@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxOutputLines     = 50
+	maxXrayErrorLines  = 10000
+	maxEndpointErrors  = 5
+	scannerLogFileName = "scanner.log"
+)
+
+var (
+	logDir     string
+	scanLogger = slog.New(slog.DiscardHandler)
+	xrayLevel  = regexp.MustCompile(`\[(Debug|Info|Warning|Error)\]`)
+)
+
+// setupLogs creates the log directory and the structured scanner log. Logs
+// of a normal run are overwritten by the next one, debug runs keep their
+// logs in their own timestamped directory.
+func setupLogs(debug bool) error {
+	logDir = filepath.Join(CORE_DIR, "log")
+	level := slog.LevelInfo
+	if debug {
+		logDir = filepath.Join(logDir, time.Now().Format("20060102-150405"))
+		level = slog.LevelDebug
+	}
+
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %w", err)
+	}
+
+	file, err := os.Create(filepath.Join(logDir, scannerLogFileName))
+	if err != nil {
+		return fmt.Errorf("error creating log file: %w", err)
+	}
+
+	scanLogger = slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level}))
+	return nil
+}
+
+func xrayLogLevel() string {
+	if *debugFlag {
+		return "debug"
+	}
+
+	return "warning"
+}
+
+func xrayAccessLog() string {
+	if *debugFlag {
+		return filepath.Join(logDir, "access.log")
+	}
+
+	return "none"
+}
+
+// xrayOutput receives Xray stdout and stderr. Every line goes to the scanner
+// log, the last lines are kept to explain startup failures and warning or
+// error lines are kept to explain failed endpoints.
+type xrayOutput struct {
+	mu     sync.Mutex
+	rest   []byte
+	tail   []string
+	errors []string
+	logger *slog.Logger
+}
+
+func newXrayOutput(batch int) *xrayOutput {
+	return &xrayOutput{
+		logger: scanLogger.With("source", "xray", "batch", batch),
+	}
+}
+
+func (o *xrayOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.rest = append(o.rest, p...)
+	for {
+		i := bytes.IndexByte(o.rest, '\n')
+		if i < 0 {
+			break
+		}
+		o.handleLine(string(bytes.TrimRight(o.rest[:i], "\r")))
+		o.rest = o.rest[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (o *xrayOutput) handleLine(line string) {
+	if line == "" {
+		return
+	}
+
+	o.tail = append(o.tail, line)
+	if len(o.tail) > maxOutputLines {
+		o.tail = o.tail[len(o.tail)-maxOutputLines:]
+	}
+
+	level := slog.LevelInfo
+	if match := xrayLevel.FindStringSubmatch(line); match != nil {
+		switch match[1] {
+		case "Debug":
+			level = slog.LevelDebug
+		case "Warning":
+			level = slog.LevelWarn
+		case "Error":
+			level = slog.LevelError
+		}
+	}
+	o.logger.Log(context.Background(), level, line)
+
+	if level >= slog.LevelWarn && len(o.errors) < maxXrayErrorLines {
+		o.errors = append(o.errors, line)
+	}
+}
+
+func (o *xrayOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return strings.TrimSpace(strings.Join(append(o.tail, string(o.rest)), "\n"))
+}
+
+// linesFor returns the distinct warning and error lines mentioning an
+// endpoint or its outbound tag. Both must appear whole, so 1.2.3.4:2408 does
+// not match 11.2.3.4:2408 or 1.2.3.4:24080.
+func (o *xrayOutput) linesFor(endpoint string, tag string) []string {
+	endpointPattern := regexp.MustCompile(`(^|[^\w.])` + regexp.QuoteMeta(endpoint) + `($|\W)`)
+	tagPattern := regexp.MustCompile(`(^|[^\w-])` + regexp.QuoteMeta(tag) + `($|[^\w-])`)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var lines []string
+	for _, line := range o.errors {
+		if slices.Contains(lines, line) {
+			continue
+		}
+		if endpointPattern.MatchString(line) || tagPattern.MatchString(line) {
+			lines = append(lines, line)
+			if len(lines) == maxEndpointErrors {
+				break
+			}
+		}
+	}
+
+	return lines
+}
@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"net"
+	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
@@ -28,17 +32,36 @@ const (
 )
 
 type ScanConfig struct {
-	EndpointCount        int
-	Ipv4Mode             bool
-	Ipv6Mode             bool
-	IPv4Retries          int
-	IPv6Retries          int
-	RetryStaggeringMs    int
-	EndpointStaggeringMs int
-	UseNoise             bool
-	UdpNoise             Noise
-	Endpoints            []string
-	OutputCount          int
+	EndpointCount     int
+	Ipv4Mode          bool
+	Ipv6Mode          bool
+	IPv4Retries       int
+	IPv6Retries       int
+	RetryStaggeringMs int
+	Concurrency       int
+	ProbesPerSecond   int
+	UseNoise          bool
+	UdpNoise          Noise
+	Endpoints         []string
+	OutputCount       int
+	Ports             []int
+	Cidrs             []string
+	EndpointsFile     string
+	Exhaustive        bool
+	Seed              int64
+	BatchSize         int
+	Engine            string
+	ThroughputCount   int
+	ThroughputURL     string
+	UploadURL         string
+	Targets           []ProbeTarget
+	TargetMode        string
+	SortBy            string
+	Weights           ScoreWeights
+	MaxLoss           float64
+	MaxLatency        int64
+	Rounds            []int
+	SurvivorPercent   int
 }
 
 var (
@@ -52,27 +75,92 @@ var (
 	xrayPath string
 )
 
+var defaultNoise = Noise{
+	Type:   "rand",
+	Packet: "50-100",
+	Delay:  "1-5",
+	Count:  5,
+}
+
 var scanConfig = ScanConfig{
-	EndpointCount: 100,
-	Ipv4Mode:      true,
-	Ipv6Mode:      false,
-	UseNoise:      true,
-	UdpNoise: Noise{
-		Type:   "rand",
-		Packet: "50-100",
-		Delay:  "1-5",
-		Count:  5,
+	EndpointCount:     100,
+	Ipv4Mode:          true,
+	Ipv6Mode:          false,
+	UseNoise:          true,
+	UdpNoise:          defaultNoise,
+	IPv4Retries:       3,
+	IPv6Retries:       3,
+	RetryStaggeringMs: 200,
+	Concurrency:       50,
+	ProbesPerSecond:   30,
+	BatchSize:         500,
+	Engine:            "xray",
+	ThroughputURL:     defaultDownloadURL,
+	UploadURL:         defaultUploadURL,
+	Targets:           defaultProbeTargets,
+	TargetMode:        "round-robin",
+	SortBy:            "score",
+	Weights:           defaultScoreWeights,
+	MaxLoss:           100,
+	SurvivorPercent:   25,
+	Ports: []int{
+		500, 854, 859, 864, 878, 880, 890, 891, 894, 903,
+		908, 928, 934, 939, 942, 943, 945, 946, 955, 968,
+		987, 988, 1002, 1010, 1014, 1018, 1070, 1074, 1180, 1387,
+		1701, 1843, 2371, 2408, 2506, 3138, 3476, 3581, 3854, 4177,
+		4198, 4233, 4500, 5279, 5956, 7103, 7152, 7156, 7281, 7559, 8319, 8742, 8854, 8886,
+	},
+	Cidrs: []string{
+		"188.114.96.0/24", "188.114.97.0/24", "188.114.98.0/24", "188.114.99.0/24",
+		"162.159.192.0/24", "162.159.193.0/24", "162.159.195.0/24", "8.34.146.0/24",
+		"8.39.214.0/24", "8.39.204.0/24", "8.6.112.0/24", "8.35.211.0/24", "8.39.125.0/24",
+		"8.47.69.0/24",
+		"2606:4700:d0::/64", "2606:4700:d1::/64",
 	},
-	IPv4Retries:          3,
-	IPv6Retries:          3,
-	RetryStaggeringMs:    200,
-	EndpointStaggeringMs: 100,
 }
 
 type ScanResult struct {
-	Endpoint string
-	Loss     float64
-	Latency  int64
+	Endpoint      string
+	Host          string
+	Port          int
+	IPVersion     int
+	Loss          float64
+	Latency       int64
+	MinLatency    int64
+	MaxLatency    int64
+	Latencies     []int64
+	MedianLatency float64
+	P90Latency    int64
+	StdDev        float64
+	Jitter        float64
+	Attempts      int
+	Successes     int
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	Errors        []string
+	Throughput    *Throughput
+	Score         float64
+	Round         int
+}
+
+func newScanResult(endpoint string) ScanResult {
+	result := ScanResult{Endpoint: endpoint}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return result
+	}
+
+	result.Host = host
+	result.Port, _ = strconv.Atoi(port)
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if addr.Is4() {
+			result.IPVersion = 4
+		} else {
+			result.IPVersion = 6
+		}
+	}
+
+	return result
 }
 
 func fmtStr(str string, color string, isBold bool) string {
@@ -98,66 +186,6 @@ func renderHeader() {
 	)
 }
 
-func generateEndpoints() {
-	ports := []int{
-		500, 854, 859, 864, 878, 880, 890, 891, 894, 903,
-		908, 928, 934, 939, 942, 943, 945, 946, 955, 968,
-		987, 988, 1002, 1010, 1014, 1018, 1070, 1074, 1180, 1387,
-		1701, 1843, 2371, 2408, 2506, 3138, 3476, 3581, 3854, 4177,
-		4198, 4233, 4500, 5279, 5956, 7103, 7152, 7156, 7281, 7559, 8319, 8742, 8854, 8886,
-	}
-
-	ipv4Prefixes := []string{
-		"188.114.96.", "188.114.97.", "188.114.98.", "188.114.99.",
-		"162.159.192.", "162.159.193.", "162.159.195.", "8.34.146.",
-		"8.39.214.", "8.39.204.", "8.6.112.", "8.35.211.", "8.39.125.",
-		"8.47.69.",
-	}
-	ipv6Prefixes := []string{
-		"2606:4700:d0::", "2606:4700:d1::",
-	}
-
-	rand.New(rand.NewSource(time.Now().UnixNano()))
-	endpoints := make([]string, 0, scanConfig.EndpointCount)
-	seen := make(map[string]bool)
-
-	ipv4Count, ipv6Count := 0, 0
-	if scanConfig.Ipv4Mode && scanConfig.Ipv6Mode {
-		ipv4Count = scanConfig.EndpointCount / 2
-		ipv6Count = scanConfig.EndpointCount - ipv4Count
-	} else if scanConfig.Ipv4Mode {
-		ipv4Count = scanConfig.EndpointCount
-	} else if scanConfig.Ipv6Mode {
-		ipv6Count = scanConfig.EndpointCount
-	}
-
-	for len(endpoints) < ipv4Count {
-		prefix := ipv4Prefixes[rand.Intn(len(ipv4Prefixes))]
-		ip := fmt.Sprintf("%s%d", prefix, rand.Intn(256))
-		endpoint := fmt.Sprintf("%s:%d", ip, ports[rand.Intn(len(ports))])
-		if !seen[endpoint] {
-			seen[endpoint] = true
-			endpoints = append(endpoints, endpoint)
-		}
-	}
-
-	for len(endpoints) < ipv4Count+ipv6Count {
-		prefix := ipv6Prefixes[rand.Intn(len(ipv6Prefixes))]
-		ip := fmt.Sprintf("[%s%x:%x:%x:%x]", prefix,
-			rand.Intn(65536), rand.Intn(65536),
-			rand.Intn(65536), rand.Intn(65536))
-		endpoint := fmt.Sprintf("%s:%d", ip, ports[rand.Intn(len(ports))])
-		if !seen[endpoint] {
-			seen[endpoint] = true
-			endpoints = append(endpoints, endpoint)
-		}
-	}
-
-	message := fmt.Sprintf("Generated %d endpoints to test", len(endpoints))
-	successMessage(message)
-	scanConfig.Endpoints = endpoints
-}
-
 func must[T any](v T, _ error) T { return v }
 
 func writeLines(path string, lines []string) error {
@@ -168,13 +196,35 @@ func renderEndpoints(results []ScanResult) {
 	message := fmt.Sprintf("Top %d Endpoints:\n", len(results))
 	successMessage(message)
 
+	headers := []string{"Endpoint", "Score", "Loss rate", "Latency", "Min / Max", "Median", "P90", "Jitter", "Std. dev."}
+	withThroughput := slices.ContainsFunc(results, func(r ScanResult) bool {
+		return r.Throughput != nil
+	})
+	if withThroughput {
+		headers = append(headers, "Download", "Upload")
+	}
+
 	var tableRows [][]string
 	for _, r := range results {
-		tableRows = append(tableRows, []string{
+		row := []string{
 			r.Endpoint,
+			fmt.Sprintf("%.1f", r.Score),
 			fmt.Sprintf("%.1f %%", r.Loss),
 			fmt.Sprintf("%d ms", r.Latency),
-		})
+			fmt.Sprintf("%d / %d ms", r.MinLatency, r.MaxLatency),
+			fmt.Sprintf("%.1f ms", r.MedianLatency),
+			fmt.Sprintf("%d ms", r.P90Latency),
+			fmt.Sprintf("%.1f ms", r.Jitter),
+			fmt.Sprintf("%.1f ms", r.StdDev),
+		}
+		if withThroughput {
+			if r.Throughput != nil {
+				row = append(row, fmt.Sprintf("%.1f Mbps", r.Throughput.DownloadMbps), fmt.Sprintf("%.1f Mbps", r.Throughput.UploadMbps))
+			} else {
+				row = append(row, "-", "-")
+			}
+		}
+		tableRows = append(tableRows, row)
 	}
 
 	table := table.New().
@@ -194,7 +244,7 @@ func renderEndpoints(results []ScanResult) {
 			}
 			return style
 		}).
-		Headers("Endpoint", "Loss rate", "Latency").
+		Headers(headers...).
 		Rows(tableRows...)
 	fmt.Println(table.Render())
 }
@@ -207,32 +257,30 @@ func successMessage(message string) {
 	fmt.Printf("\n%s %s\n", succMark, message)
 }
 
-func init() {
+// setup parses the command line, then prepares the logs, the Xray core path
+// and the Termux certificates before anything runs.
+func setup() {
 	showVersion := flag.Bool("version", false, "Show version")
-	flag.Parse()
+	if len(os.Args) > 1 && (os.Args[1] == "monitor" || os.Args[1] == "serve") {
+		command = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	if *showVersion {
 		fmt.Println(VERSION)
 		os.Exit(0)
 	}
+	// Commands run unattended.
+	nonInteractive = command != ""
+	detectNonInteractive()
 
-	logDir := filepath.Join(CORE_DIR, "log")
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		failMessage("Failed to create Xray log directory")
+	if err := setupLogs(*debugFlag); err != nil {
+		failMessage("Failed to create log files.")
 		log.Fatal(err)
 	}
 
-	accessLog := filepath.Join(logDir, "access.log")
-	errorLog := filepath.Join(logDir, "error.log")
-	for _, file := range []string{accessLog, errorLog} {
-		file, err := os.Create(file)
-		if err != nil {
-			failMessage("Failed to create Xray log file")
-			log.Fatal(err)
-		}
-		defer file.Close()
-	}
-
 	var binary string
 	if runtime.GOOS == "windows" {
 		binary = "xray.exe"
@@ -241,17 +289,6 @@ func init() {
 	}
 	xrayPath = filepath.Join(CORE_DIR, binary)
 
-	if _, err := os.Stat(xrayPath); err != nil {
-		failMessage("Xray core not found.")
-		log.Fatal(err)
-	}
-
-	err := os.Chmod(xrayPath, 0755)
-	if err != nil {
-		failMessage("Failed to set Xray core permissions.")
-		log.Fatal(err)
-	}
-
 	path := os.Getenv("PATH")
 	if runtime.GOOS == "android" || strings.Contains(path, "com.termux") {
 		prefix := os.Getenv("PREFIX")
@@ -282,7 +319,7 @@ func isValidHex(value string) bool {
 		return false
 	}
 
-	return len(value) > 0 && matched
+	return len(value) > 0 && len(value)%2 == 0 && matched
 }
 
 func isValidBase64(value string) bool {
@@ -315,7 +352,7 @@ func isValidRange(value string) bool {
 	return matched
 }
 
-func main() {
+func promptScanConfig() {
 	fmt.Printf("\n%s Quick scan - 100 endpoints", fmtStr("1.", BLUE, true))
 	fmt.Printf("\n%s Normal scan - 1000 endpoints", fmtStr("2.", BLUE, true))
 	fmt.Printf("\n%s Deep scan - 10000 endpoints", fmtStr("3.", BLUE, true))
@@ -495,38 +532,174 @@ func main() {
 		}
 	}
 
-	if scanConfig.Ipv4Mode {
-		checkNetworkStats(false)
+	var save string
+	fmt.Printf("\n%s Save these answers as a profile? (y/N): ", prompt)
+	fmt.Scanln(&save)
+	if strings.EqualFold(save, "y") {
+		path := "profile.json"
+		fmt.Printf("\n%s Profile path (default %s): ", prompt, fmtStr(path, GREEN, true))
+		fmt.Scanln(&path)
+		if err := saveProfile(path); err != nil {
+			failMessage(err.Error())
+		} else {
+			message := fmt.Sprintf("Profile saved to %s, run with -config %s to reuse it.", path, path)
+			successMessage(message)
+		}
+	}
+}
+
+// completeScan ranks the scanned endpoints and runs the throughput stage if
+// enabled. It returns the ranked endpoints, and all endpoints for the JSON
+// outputs, which keep filtered out and failed ones with their errors after
+// the ranked ones.
+func completeScan(ctx context.Context, scanned []ScanResult) ([]ScanResult, []ScanResult) {
+	results := rankResults(scanned)
+	if scanConfig.ThroughputCount > 0 && len(results) > 0 {
+		if err := testThroughput(ctx, results); err != nil {
+			failMessage(fmt.Sprintf("Throughput test failed: %v", err))
+		}
+	}
+
+	ranked := make(map[string]bool, len(results))
+	for _, r := range results {
+		ranked[r.Endpoint] = true
+	}
+	all := append(slices.Clone(results), slices.DeleteFunc(scanned, func(r ScanResult) bool {
+		return ranked[r.Endpoint]
+	})...)
+
+	return results, all
+}
+
+func main() {
+	setup()
+
+	if err := validateOutputFlags(); err != nil {
+		exitWithUsage(err)
+	}
+
+	if err := validateAccountFlags(); err != nil {
+		exitWithUsage(err)
+	}
+
+	if command == "monitor" {
+		if err := validateMonitorFlags(); err != nil {
+			exitWithUsage(err)
+		}
+	}
+
+	if command == "serve" || command == "monitor" && isFlagSet("listen") {
+		if err := validateListenAddress(*listenFlag); err != nil {
+			exitWithUsage(err)
+		}
+	}
+
+	if nonInteractive {
+		if err := applyFlags(); err != nil {
+			exitWithUsage(err)
+		}
+	} else {
+		promptScanConfig()
+	}
+
+	// Posted scans pick their own engine, so the server checks for Xray core
+	// per scan.
+	if command == "serve" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runServer(ctx); err != nil {
+			failMessage("Server failed.")
+			log.Fatal(err)
+		}
+		return
 	}
-	if scanConfig.Ipv6Mode {
-		checkNetworkStats(true)
+
+	if scanConfig.Engine == "xray" {
+		if err := prepareXrayCore(); err != nil {
+			failMessage("Xray core is missing or not executable, use -engine native to scan without it.")
+			log.Fatal(err)
+		}
 	}
 
-	generateEndpoints()
+	if command == "monitor" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runMonitor(ctx); err != nil {
+			failMessage("Monitor failed.")
+			log.Fatal(err)
+		}
+		return
+	}
 
-	results, err := scanEndpoints()
+	// A loaded profile pins retries, so skip adjusting them to the network.
+	if !profileLoaded {
+		if scanConfig.Ipv4Mode {
+			checkNetworkStats(false)
+		}
+		if scanConfig.Ipv6Mode {
+			checkNetworkStats(true)
+		}
+	}
+
+	if err := generateEndpoints(); err != nil {
+		failMessage("Failed to generate endpoints.")
+		log.Fatal(err)
+	}
+
+	scanned, err := scanEndpoints(context.Background())
 	if err != nil {
 		failMessage("Scan failed.")
 		log.Fatal(err)
 	}
 
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Latency < results[j].Latency
-	})
+	results, scanned := completeScan(context.Background(), scanned)
 
-	lines := make([]string, 0, len(results)+1)
-	lines = append(lines, "Endpoint,Loss rate,Avg. Latency")
-	for _, r := range results {
-		lines = append(lines, fmt.Sprintf("%s,%.2f %%,%d ms", r.Endpoint, r.Loss, r.Latency))
-	}
-	if err := writeLines("result.csv", lines); err != nil {
+	outputs := []string{"result.csv"}
+	if err := writeCsv("result.csv", results); err != nil {
 		fmt.Printf("Error saving working IPs: %v\n", err)
 	}
 
+	switch *formatFlag {
+	case "json":
+		outputs = append(outputs, "result.json")
+		if err := writeJson("result.json", scanned); err != nil {
+			fmt.Printf("Error saving JSON results: %v\n", err)
+		}
+	case "jsonl":
+		outputs = append(outputs, "result.jsonl")
+		if err := writeJsonLines("result.jsonl", scanned); err != nil {
+			fmt.Printf("Error saving JSON Lines results: %v\n", err)
+		}
+	}
+
 	renderEndpoints(results[:min(scanConfig.OutputCount, len(results))])
+
+	if len(selectedExports) > 0 && len(results) > 0 {
+		exportCount := scanConfig.OutputCount
+		if *exportCountFlag > 0 {
+			exportCount = *exportCountFlag
+		}
+		best := results[:min(exportCount, len(results))]
+		files, err := exportEndpoints(best, warpParams, selectedExports)
+		if err != nil {
+			failMessage(fmt.Sprintf("Export failed: %v", err))
+		}
+		if len(files) > 0 {
+			message := fmt.Sprintf("Exported top %d endpoints to %s", len(best), strings.Join(files, ", "))
+			successMessage(message)
+		}
+		if slices.Contains(selectedExports, "bpb") {
+			fmt.Printf("\n%s BPB Panel endpoints: %s\n", prompt, buildBpbEndpoints(best))
+		}
+	}
 	successMessage("Scan completed.")
-	message := fmt.Sprintf("Found %d endpoints. You can check result.csv for more details.\n", len(results))
+	message := fmt.Sprintf("Found %d endpoints. You can check %s for more details.\n", len(results), strings.Join(outputs, " and "))
 	successMessage(message)
-	fmt.Printf("%s Press any key to exit...", prompt)
-	fmt.Scanln()
+	if *debugFlag {
+		fmt.Printf("%s Debug logs are kept in %s\n\n", prompt, logDir)
+	}
+	if !nonInteractive {
+		fmt.Printf("%s Press any key to exit...", prompt)
+		fmt.Scanln()
+	}
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are kept in a small registry and written in the Prometheus text
+// format, which is all /metrics needs.
+var (
+	probesTotal    = newMetricVec("bpb_probes_total", "Probes sent to endpoints.", "counter", "engine")
+	probeSuccesses = newMetricVec("bpb_probe_successes_total", "Probes answered in time.", "counter", "engine")
+	probeFailures  = newMetricVec("bpb_probe_failures_total", "Failed probes by reason.", "counter", "engine", "reason")
+	probeLatency   = newHistogramVec("bpb_probe_latency_seconds", "Latency of successful probes.",
+		[]float64{0.025, 0.05, 0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2}, "engine")
+	endpointLatency   = newMetricVec("bpb_endpoint_latency_seconds", "Average latency of monitored endpoints and the best endpoints of the last API scan.", "gauge", "endpoint")
+	endpointLoss      = newMetricVec("bpb_endpoint_loss_percent", "Loss rate of monitored endpoints and the best endpoints of the last API scan.", "gauge", "endpoint")
+	xrayStarts        = newMetricVec("bpb_xray_starts_total", "Xray core processes started, one per batch, monitor cycle or throughput test.", "counter", "result")
+	warpRegistrations = newMetricVec("bpb_warp_registrations_total", "Warp account registrations.", "counter", "result")
+
+	registry = []metric{probesTotal, probeSuccesses, probeFailures, probeLatency, endpointLatency, endpointLoss, xrayStarts, warpRegistrations}
+)
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// metricVec is a counter or gauge with one value per label set.
+type metricVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	values map[string]float64
+}
+
+func newMetricVec(name, help, kind string, labels ...string) *metricVec {
+	return &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+func (m *metricVec) Add(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[formatLabels(m.labels, labelValues)] += value
+}
+
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *metricVec) Set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[formatLabels(m.labels, labelValues)] = value
+}
+
+// Reset drops all values, for gauges that describe a new set of endpoints.
+func (m *metricVec) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.values)
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, labels := range slices.Sorted(maps.Keys(m.values)) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, labels, formatValue(m.values[labels]))
+	}
+}
+
+// histogramVec counts observations in cumulative buckets per label set.
+type histogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		labels:  labels,
+		series:  make(map[string]*histogram),
+	}
+}
+
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := formatLabels(h.labels, labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[key]
+		bucketLabels := append(slices.Clone(h.labels), "le")
+		for i, bound := range h.buckets {
+			labels := formatLabels(bucketLabels, append(slices.Clone(s.labelValues), formatValue(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i])
+		}
+		labels := formatLabels(bucketLabels, append(slices.Clone(s.labelValues), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// formatLabels renders a label set like {engine="xray"}, values are escaped
+// as the text format requires.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escaper.Replace(value)))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeMetrics(w io.Writer) {
+	for _, m := range registry {
+		m.write(w)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+// recordProbe counts a probe of the current engine and why it failed.
+func recordProbe(attempt Attempt) {
+	engine := scanConfig.Engine
+	probesTotal.Inc(engine)
+	if attempt.Err != nil {
+		probeFailures.Inc(engine, failureReason(attempt.Err))
+		return
+	}
+
+	probeSuccesses.Inc(engine)
+	probeLatency.Observe(attempt.Latency.Seconds(), engine)
+}
+
+// failureReason sorts probe errors into a few stable reasons, so the
+// failure metric does not get a series per error message.
+func failureReason(err error) string {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), isTimeout(err):
+		return "timeout"
+	case errors.Is(err, errCookieReply):
+		return "cookie_reply"
+	case errors.Is(err, errUnexpectedStatus):
+		return "http_status"
+	case errors.Is(err, errBodyMismatch):
+		return "body_mismatch"
+	case errors.As(err, &opErr):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// recordEndpoints replaces the endpoint gauges with the given results.
+func recordEndpoints(results []ScanResult) {
+	endpointLatency.Reset()
+	endpointLoss.Reset()
+	for _, r := range results {
+		endpointLatency.Set(float64(r.Latency)/1000, r.Endpoint)
+		endpointLoss.Set(r.Loss, r.Endpoint)
+	}
+}
+
+// serveMetrics serves /metrics on address until ctx is done, for commands
+// that have no API server of their own.
+func serveMetrics(ctx context.Context, address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	server := &http.Server{
+		Addr:              address,
+		Handler:           allowLocalHosts(address, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		failMessage(fmt.Sprintf("Metrics server failed: %v", err))
+		scanLogger.Warn("metrics server failed", "error", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetMetrics clears every metric of the registry, so tests start from no
+// recorded values.
+func resetMetrics(t *testing.T) {
+	t.Helper()
+
+	reset := func() {
+		for _, m := range registry {
+			switch m := m.(type) {
+			case *metricVec:
+				m.Reset()
+			case *histogramVec:
+				m.mu.Lock()
+				clear(m.series)
+				m.mu.Unlock()
+			}
+		}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestWriteMetrics(t *testing.T) {
+	config := scanConfig
+	config.Engine = "native"
+	setScanConfig(t, config)
+	resetMetrics(t)
+
+	recordProbe(Attempt{Latency: 250 * time.Millisecond})
+	recordProbe(Attempt{Latency: 500 * time.Millisecond})
+	recordProbe(Attempt{Err: context.DeadlineExceeded})
+	recordProbe(Attempt{Err: errCookieReply})
+	recordEndpoints([]ScanResult{
+		{Endpoint: "162.159.192.1:2408", Latency: 120, Loss: 25},
+		{Endpoint: "[2606:4700:d0::1]:2408", Latency: 85},
+	})
+	xrayStarts.Inc("success")
+	warpRegistrations.Inc("failure")
+
+	var out strings.Builder
+	writeMetrics(&out)
+	if got := out.String(); got != wantMetrics {
+		t.Errorf("got metrics:\n%s\nwant:\n%s", got, wantMetrics)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels([]string{"endpoint", "reason"}, []string{`a"b\c`, "line\nbreak"})
+	if want := `{endpoint="a\"b\\c",reason="line\nbreak"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+const wantMetrics = `# HELP bpb_probes_total Probes sent to endpoints.
+# TYPE bpb_probes_total counter
+bpb_probes_total{engine="native"} 4
+# HELP bpb_probe_successes_total Probes answered in time.
+# TYPE bpb_probe_successes_total counter
+bpb_probe_successes_total{engine="native"} 2
+# HELP bpb_probe_failures_total Failed probes by reason.
+# TYPE bpb_probe_failures_total counter
+bpb_probe_failures_total{engine="native",reason="cookie_reply"} 1
+bpb_probe_failures_total{engine="native",reason="timeout"} 1
+# HELP bpb_probe_latency_seconds Latency of successful probes.
+# TYPE bpb_probe_latency_seconds histogram
+bpb_probe_latency_seconds_bucket{engine="native",le="0.025"} 0
+bpb_probe_latency_seconds_bucket{engine="native",le="0.05"} 0
+bpb_probe_latency_seconds_bucket{engine="native",le="0.1"} 0
+bpb_probe_latency_seconds_bucket{engine="native",le="0.2"} 0
+bpb_probe_latency_seconds_bucket{engine="native",le="0.3"} 1
+bpb_probe_latency_seconds_bucket{engine="native",le="0.5"} 2
+bpb_probe_latency_seconds_bucket{engine="native",le="0.75"} 2
+bpb_probe_latency_seconds_bucket{engine="native",le="1"} 2
+bpb_probe_latency_seconds_bucket{engine="native",le="1.5"} 2
+bpb_probe_latency_seconds_bucket{engine="native",le="2"} 2
+bpb_probe_latency_seconds_bucket{engine="native",le="+Inf"} 2
+bpb_probe_latency_seconds_sum{engine="native"} 0.75
+bpb_probe_latency_seconds_count{engine="native"} 2
+# HELP bpb_endpoint_latency_seconds Average latency of monitored endpoints and the best endpoints of the last API scan.
+# TYPE bpb_endpoint_latency_seconds gauge
+bpb_endpoint_latency_seconds{endpoint="162.159.192.1:2408"} 0.12
+bpb_endpoint_latency_seconds{endpoint="[2606:4700:d0::1]:2408"} 0.085
+# HELP bpb_endpoint_loss_percent Loss rate of monitored endpoints and the best endpoints of the last API scan.
+# TYPE bpb_endpoint_loss_percent gauge
+bpb_endpoint_loss_percent{endpoint="162.159.192.1:2408"} 25
+bpb_endpoint_loss_percent{endpoint="[2606:4700:d0::1]:2408"} 0
+# HELP bpb_xray_starts_total Xray core processes started, one per batch, monitor cycle or throughput test.
+# TYPE bpb_xray_starts_total counter
+bpb_xray_starts_total{result="success"} 1
+# HELP bpb_warp_registrations_total Warp account registrations.
+# TYPE bpb_warp_registrations_total counter
+bpb_warp_registrations_total{result="failure"} 1
+`
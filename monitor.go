@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	monitorResultsFile = "result.csv"
+	webhookTimeout     = 5 * time.Second
+)
+
+// MonitorEvent is emitted when a monitored endpoint degrades or recovers, or
+// when the background scan finds a better endpoint.
+type MonitorEvent struct {
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	Endpoint     string    `json:"endpoint"`
+	LossPercent  float64   `json:"lossPercent"`
+	AvgLatencyMs int64     `json:"avgLatencyMs"`
+	Message      string    `json:"message"`
+}
+
+func validateMonitorFlags() error {
+	if *intervalFlag <= 0 {
+		return fmt.Errorf("invalid -interval %s, it should be positive", *intervalFlag)
+	}
+
+	if isValid, _ := checkNum(strconv.Itoa(*windowFlag), 1, 1000); !isValid {
+		return fmt.Errorf("invalid -window %d, it should be between 1-1000", *windowFlag)
+	}
+
+	if *alertLossFlag < 0 || *alertLossFlag > 100 {
+		return fmt.Errorf("invalid -alert-loss %g, it should be between 0-100 percent", *alertLossFlag)
+	}
+
+	if *alertLatencyFlag < 0 {
+		return fmt.Errorf("invalid -alert-latency %d, it can not be negative, use 0 to disable", *alertLatencyFlag)
+	}
+
+	if *webhookFlag != "" {
+		if err := validateThroughputURL("-webhook", *webhookFlag, true); err != nil {
+			return err
+		}
+	}
+
+	if *discoverFlag < 0 {
+		return fmt.Errorf("invalid -discover %d, it can not be negative, use 0 to disable", *discoverFlag)
+	}
+
+	return nil
+}
+
+// readResultEndpoints reads the endpoints column of a result.csv written by
+// an earlier scan.
+func readResultEndpoints(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading results: %w", err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	var endpoints []string
+	for _, record := range records[min(1, len(records)):] {
+		endpoints = append(endpoints, record[0])
+	}
+
+	return endpoints, nil
+}
+
+// monitorEndpoints returns the endpoints given with -endpoints-file, or the
+// best -top endpoints found by the last scan.
+func monitorEndpoints() ([]string, error) {
+	if scanConfig.EndpointsFile == "" {
+		endpoints, err := readResultEndpoints(monitorResultsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// result.csv is ranked, so the best endpoints come first.
+		return endpoints[:min(scanConfig.OutputCount, len(endpoints))], nil
+	}
+
+	addrs, err := readEndpointsFile(scanConfig.EndpointsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, addr.String())
+	}
+
+	return endpoints, nil
+}
+
+// monitor keeps the results of the last cycles of every endpoint and whether
+// it is currently degraded, so alerts fire once per change.
+type monitor struct {
+	endpoints []string
+	history   map[string][]ScanResult
+	degraded  map[string]bool
+	reported  map[string]bool
+	limiter   *rateLimiter
+	client    *http.Client
+}
+
+// runMonitor probes the monitored endpoints every -interval until ctx is
+// done, alerting when their rolling loss or latency crosses the thresholds.
+// With -discover, a scan of random endpoints runs next to every cycle and
+// reports endpoints ranking above all monitored ones.
+func runMonitor(ctx context.Context) error {
+	endpoints, err := monitorEndpoints()
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no endpoints to monitor, run a scan first or pass -endpoints-file")
+	}
+
+	params, err := getWarpParams()
+	if err != nil {
+		return fmt.Errorf("error registering Warp account: %w", err)
+	}
+	warpParams = params
+
+	m := &monitor{
+		endpoints: endpoints,
+		history:   make(map[string][]ScanResult, len(endpoints)),
+		degraded:  make(map[string]bool, len(endpoints)),
+		reported:  make(map[string]bool),
+		limiter:   newRateLimiter(scanConfig.ProbesPerSecond),
+		client:    &http.Client{Timeout: webhookTimeout},
+	}
+	defer m.limiter.Stop()
+
+	message := fmt.Sprintf("Monitoring %d endpoints every %s, press Ctrl+C to stop.", len(endpoints), *intervalFlag)
+	successMessage(message)
+
+	if isFlagSet("listen") {
+		go serveMetrics(ctx, *listenFlag)
+		fmt.Printf("\n%s Serving metrics on http://%s/metrics\n", prompt, *listenFlag)
+	}
+
+	ticker := time.NewTicker(*intervalFlag)
+	defer ticker.Stop()
+	discovered := make(chan []ScanResult, 1)
+	discovering := false
+
+	for cycle := 1; ; cycle++ {
+		if err := m.probe(ctx, cycle); err != nil {
+			return err
+		}
+
+		if *discoverFlag > 0 && !discovering {
+			discovering = true
+			go func() {
+				discovered <- m.discover(ctx)
+			}()
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				successMessage("Monitor stopped.")
+				return nil
+			case results := <-discovered:
+				discovering = false
+				m.compare(ctx, results)
+			case <-ticker.C:
+				break wait
+			}
+		}
+	}
+}
+
+// probe runs one cycle over the monitored endpoints and checks their rolling
+// statistics against the thresholds.
+func (m *monitor) probe(ctx context.Context, cycle int) error {
+	fmt.Printf("\n%s Cycle %d at %s\n\n", prompt, cycle, time.Now().Format(time.TimeOnly))
+	results, err := scanBatches(ctx, m.endpoints, configuredRetries, m.limiter)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	for _, r := range results {
+		history := append(m.history[r.Endpoint], r)
+		m.history[r.Endpoint] = history[max(len(history)-*windowFlag, 0):]
+	}
+
+	rolling := m.rolling()
+	recordEndpoints(rolling)
+	for _, r := range rolling {
+		degraded := r.Loss > *alertLossFlag || *alertLatencyFlag > 0 && r.Latency > *alertLatencyFlag
+		switch {
+		case degraded && !m.degraded[r.Endpoint]:
+			m.emit(ctx, newMonitorEvent("degraded", r, fmt.Sprintf("%s degraded", r.Endpoint)))
+		case !degraded && m.degraded[r.Endpoint]:
+			m.emit(ctx, newMonitorEvent("recovered", r, fmt.Sprintf("%s recovered", r.Endpoint)))
+		}
+		m.degraded[r.Endpoint] = degraded
+	}
+
+	ranked := rankResults(rolling)
+	if len(ranked) > 0 {
+		renderEndpoints(ranked)
+	}
+
+	return nil
+}
+
+// rolling merges the cycles kept for every endpoint into one result.
+func (m *monitor) rolling() []ScanResult {
+	results := make([]ScanResult, 0, len(m.endpoints))
+	for _, endpoint := range m.endpoints {
+		history := m.history[endpoint]
+		if len(history) == 0 {
+			continue
+		}
+
+		r := history[0]
+		for _, next := range history[1:] {
+			r = mergeResults(r, next)
+		}
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// discover scans random endpoints of the configured ranges and ports that
+// are not monitored yet, and returns the working ones ranked.
+func (m *monitor) discover(ctx context.Context) []ScanResult {
+	source, err := newEndpointSource(scanConfig)
+	if err != nil {
+		scanLogger.Warn("discovery skipped", "error", err)
+		return nil
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	prefixes := slices.Concat(source.ipv4Prefixes, source.ipv6Prefixes)
+	candidates := slices.DeleteFunc(randomEndpoints(rng, prefixes, source.ports, *discoverFlag), func(endpoint string) bool {
+		return slices.Contains(m.endpoints, endpoint)
+	})
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	fmt.Printf("\n%s Background scan of %d endpoints...\n\n", prompt, len(candidates))
+	results, err := scanBatches(ctx, candidates, configuredRetries, m.limiter)
+	if err != nil {
+		scanLogger.Warn("discovery failed", "error", err)
+		return nil
+	}
+
+	return rankResults(results)
+}
+
+// compare reports the best discovered endpoint when it ranks above every
+// monitored one. Each endpoint is only reported once.
+func (m *monitor) compare(ctx context.Context, discovered []ScanResult) {
+	if len(discovered) == 0 || m.reported[discovered[0].Endpoint] {
+		return
+	}
+
+	best := discovered[0]
+	if ranked := rankResults(append(m.rolling(), best)); ranked[0].Endpoint != best.Endpoint {
+		return
+	}
+
+	m.reported[best.Endpoint] = true
+	m.emit(ctx, newMonitorEvent("better-endpoint", best, fmt.Sprintf("%s ranks above all monitored endpoints", best.Endpoint)))
+}
+
+func newMonitorEvent(eventType string, r ScanResult, message string) MonitorEvent {
+	return MonitorEvent{
+		Time:         time.Now(),
+		Type:         eventType,
+		Endpoint:     r.Endpoint,
+		LossPercent:  r.Loss,
+		AvgLatencyMs: r.Latency,
+		Message:      message,
+	}
+}
+
+// emit prints and logs an event, and POSTs it to the webhook if one is set.
+func (m *monitor) emit(ctx context.Context, event MonitorEvent) {
+	details := fmt.Sprintf("%s - Loss rate: %.1f %% - Avg. Latency: %d ms", event.Message, event.LossPercent, event.AvgLatencyMs)
+	if event.Type == "degraded" {
+		failMessage(details)
+	} else {
+		successMessage(details)
+	}
+	scanLogger.Info("monitor event", "type", event.Type, "endpoint", event.Endpoint, "loss", event.LossPercent, "latency", event.AvgLatencyMs)
+
+	if *webhookFlag == "" {
+		return
+	}
+	if err := m.post(ctx, event); err != nil {
+		failMessage(fmt.Sprintf("Webhook failed: %v", err))
+		scanLogger.Warn("webhook failed", "error", err)
+	}
+}
+
+func (m *monitor) post(ctx context.Context, event MonitorEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *webhookFlag, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	return nil
+}
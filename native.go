@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// nativeTimeout is how long a native probe waits for the handshake response.
+const nativeTimeout = 2 * time.Second
+
+// nativeProber probes endpoints with WireGuard handshakes sent directly over
+// UDP, preceded by the same UDP noise Xray would send, so no Xray process is
+// needed. Latency is the handshake round trip.
+type nativeProber struct {
+	keys  wgKeys
+	noise *noiseSender
+}
+
+func newNativeProber(params WarpParams) (*nativeProber, error) {
+	keys, err := newWgKeys(params)
+	if err != nil {
+		return nil, err
+	}
+
+	prober := &nativeProber{keys: keys}
+	if scanConfig.UseNoise {
+		if prober.noise, err = newNoiseSender(scanConfig.UdpNoise); err != nil {
+			return nil, err
+		}
+	}
+
+	return prober, nil
+}
+
+func (p *nativeProber) Probe(ctx context.Context, endpoint string) Attempt {
+	rtt, err := wgHandshakeRTT(ctx, endpoint, p.keys, p.noise, nativeTimeout)
+	return Attempt{Latency: rtt, Err: err}
+}
+
+func (p *nativeProber) Close() error {
+	return nil
+}
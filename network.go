@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -71,7 +70,6 @@ func initHttpClient(preferIPv6 bool) {
 func checkNetworkStats(preferIPv6 bool) {
 	fmt.Printf("\n%s Determining network quality to adjust scan options...\n\n", prompt)
 	const (
-		testTargetURL     = "http://www.google.com/generate_204"
 		initialTestCount  = 100
 		goodLatencyMs     = 50
 		moderateLatencyMs = 100
@@ -111,25 +109,18 @@ func checkNetworkStats(preferIPv6 bool) {
 	)
 
 	initHttpClient(preferIPv6)
+	targets := newTargetChecker(scanConfig.Targets, scanConfig.TargetMode)
 	for range initialTestCount {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			concurrencyLimiter <- struct{}{}
 			defer func() { <-concurrencyLimiter }()
-			start := time.Now()
-			resp, err := httpClient.Head(testTargetURL)
+			latency, err := targets.check(context.Background(), httpClient)
 			bar.Add(1)
-			latency := time.Since(start).Milliseconds()
-			if err == nil && resp.StatusCode == http.StatusNoContent {
-				if resp.Body != nil {
-					resp.Body.Close()
-				}
-				latencyResults <- latency
+			if err == nil {
+				latencyResults <- latency.Milliseconds()
 			} else {
-				if resp != nil && resp.Body != nil {
-					resp.Body.Close()
-				}
 				latencyResults <- -1
 			}
 		}()
@@ -193,109 +184,145 @@ func checkNetworkStats(preferIPv6 bool) {
 	}
 }
 
-func scanEndpoints() ([]ScanResult, error) {
-	err := createXrayConfig()
+// scanEndpoints scans the endpoints with the selected engine. Xray scans run
+// in batches of BatchSize, each batch with its own Xray config and process,
+// so memory and open sockets stay bounded however many endpoints are scanned.
+func scanEndpoints(ctx context.Context) ([]ScanResult, error) {
+	params, err := getWarpParams()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error registering Warp account: %w", err)
 	}
+	warpParams = params
+
+	limiter := newRateLimiter(scanConfig.ProbesPerSecond)
+	defer limiter.Stop()
 
-	cmd, err := runXrayCore()
+	if len(scanConfig.Rounds) > 0 {
+		return scanTournament(ctx, limiter)
+	}
+
+	scanProgress.start(1, len(scanConfig.Endpoints))
+	results, err := scanBatches(ctx, scanConfig.Endpoints, configuredRetries, limiter)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 
-	var wg sync.WaitGroup
-	results := make(chan ScanResult, len(scanConfig.Endpoints))
-	transports := make([]*http.Transport, len(scanConfig.Endpoints))
+	return results, ctx.Err()
+}
 
-	for i, endpoint := range scanConfig.Endpoints {
-		wg.Add(1)
-		go func(endpoint string, portIdx int) {
-			defer wg.Done()
-			time.Sleep(time.Duration(portIdx*scanConfig.EndpointStaggeringMs) * time.Millisecond)
-			proxyURL := must(url.Parse(fmt.Sprintf("http://127.0.0.1:%d", 1080+portIdx)))
-			transport := &http.Transport{
-				Proxy: http.ProxyURL(proxyURL),
-			}
-			transports[portIdx] = transport
+// scanBatches probes the endpoints, split into batches of BatchSize for the
+// Xray engine so each batch gets its own Xray process.
+func scanBatches(ctx context.Context, endpoints []string, retries func(string) int, limiter *rateLimiter) ([]ScanResult, error) {
+	batchSize := len(endpoints)
+	if scanConfig.Engine == "xray" {
+		batchSize = max(scanConfig.BatchSize, 1)
+	}
+	var allResults []ScanResult
 
-			currentRetries := scanConfig.IPv4Retries
-			if scanConfig.Ipv6Mode && !scanConfig.Ipv4Mode ||
-				scanConfig.Ipv4Mode && scanConfig.Ipv6Mode && i >= len(scanConfig.Endpoints)/2 {
-				currentRetries = scanConfig.IPv6Retries
-			}
+	for offset := 0; offset < len(endpoints) && ctx.Err() == nil; offset += batchSize {
+		batch := endpoints[offset:min(offset+batchSize, len(endpoints))]
+		if len(endpoints) > batchSize {
+			fmt.Printf("\n%s Scanning batch %d of %d...\n", prompt, offset/batchSize+1, (len(endpoints)+batchSize-1)/batchSize)
+		}
 
-			var successCount int
-			var totalLatency int64
+		prober, err := startProber(batch, offset, warpParams)
+		if err != nil {
+			return nil, err
+		}
 
-			var innerWg sync.WaitGroup
-			latencies := make(chan int64, currentRetries)
+		results := runProbes(ctx, prober, batch, offset, retries, limiter)
+		if err := prober.Close(); err != nil {
+			return nil, err
+		}
+		allResults = append(allResults, results...)
+	}
 
-			for t := range currentRetries {
-				innerWg.Add(1)
-				go func(delay int) {
-					defer innerWg.Done()
-					time.Sleep(time.Duration(delay) * time.Millisecond)
-					client := &http.Client{
-						Timeout:   2 * time.Second,
-						Transport: transport,
-					}
+	return allResults, nil
+}
 
-					start := time.Now()
-					resp, err := client.Head("http://www.gstatic.com/generate_204")
-					latency := time.Since(start).Milliseconds()
-					if err == nil && resp.StatusCode == 204 {
-						if resp.Body != nil {
-							resp.Body.Close()
-						}
-						latencies <- latency
-					} else {
-						latencies <- -1
-					}
-				}(t * scanConfig.RetryStaggeringMs)
-			}
-			innerWg.Wait()
-			close(latencies)
+// xrayProber sends HTTP probes through the Xray inbound of each endpoint.
+type xrayProber struct {
+	xray       *xrayProcess
+	targets    *targetChecker
+	offset     int
+	indexes    map[string]int
+	transports map[string]*http.Transport
+}
 
-			for l := range latencies {
-				if l >= 0 {
-					successCount++
-					totalLatency += l
-				}
-			}
+// xrayStartMu serializes Xray startups, which share one config file.
+var xrayStartMu sync.Mutex
 
-			if successCount == 0 {
-				log.Printf("[%d] %s -> %s\n", i+1, fmtStr(endpoint, ORANGE, false), fmtStr("Failed", RED, true))
-			} else {
-				avgLatency := totalLatency / int64(successCount)
-				lossRate := float64(currentRetries-successCount) / float64(currentRetries) * 100
-				results <- ScanResult{Endpoint: endpoint, Loss: lossRate, Latency: avgLatency}
-				log.Printf("[%d] %s -> %s - %s %.1f %% - %s %d ms\n",
-					i+1,
-					fmtStr(endpoint, ORANGE, false),
-					fmtStr("Success", GREEN, true),
-					fmtStr("Loss rate:", "", true),
-					lossRate,
-					fmtStr("Avg. Latency:", "", true),
-					avgLatency,
-				)
-			}
-		}(endpoint, i)
+// newXrayProber starts an Xray process with an http inbound and wireguard
+// outbound for each endpoint of the batch.
+func newXrayProber(endpoints []string, offset int, params WarpParams) (*xrayProber, error) {
+	xrayStartMu.Lock()
+	defer xrayStartMu.Unlock()
+
+	ports, err := allocatePorts(len(endpoints))
+	if err != nil {
+		return nil, err
 	}
-	wg.Wait()
-	close(results)
 
-	var allResults []ScanResult
-	for r := range results {
-		allResults = append(allResults, r)
+	if err := createXrayConfig(endpoints, ports, offset, params); err != nil {
+		return nil, err
+	}
+
+	xray, err := runXrayCore(ports, offset/max(scanConfig.BatchSize, 1)+1, *xrayTimeoutFlag)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := cmd.Process.Kill(); err != nil {
-		return nil, fmt.Errorf("error killing Xray core: %w", err)
+	prober := &xrayProber{
+		xray:       xray,
+		targets:    newTargetChecker(scanConfig.Targets, scanConfig.TargetMode),
+		offset:     offset,
+		indexes:    make(map[string]int, len(endpoints)),
+		transports: make(map[string]*http.Transport, len(endpoints)),
+	}
+	for j, endpoint := range endpoints {
+		proxyURL := must(url.Parse(fmt.Sprintf("http://127.0.0.1:%d", ports[j])))
+		prober.indexes[endpoint] = j
+		prober.transports[endpoint] = &http.Transport{
+			Proxy: http.ProxyURL(proxyURL),
+		}
 	}
 
-	cmd.Wait()
+	return prober, nil
+}
 
-	return allResults, nil
+func (p *xrayProber) Probe(ctx context.Context, endpoint string) Attempt {
+	transport, ok := p.transports[endpoint]
+	if !ok {
+		return Attempt{Err: fmt.Errorf("endpoint %s is not part of this Xray batch", endpoint)}
+	}
+	client := &http.Client{
+		Timeout:   2 * time.Second,
+		Transport: transport,
+	}
+
+	latency, err := p.targets.check(ctx, client)
+	return Attempt{Latency: latency, Err: err}
+}
+
+// explain returns the Xray warnings and errors about the endpoint or its
+// outbound, like failed WireGuard handshakes.
+func (p *xrayProber) explain(endpoint string) []string {
+	return p.xray.output.linesFor(endpoint, fmt.Sprintf("proxy-%d", p.offset+p.indexes[endpoint]+1))
+}
+
+func (p *xrayProber) Throughput(ctx context.Context, endpoint string) Throughput {
+	transport, ok := p.transports[endpoint]
+	if !ok {
+		return Throughput{Error: fmt.Sprintf("endpoint %s is not part of this Xray batch", endpoint)}
+	}
+
+	return throughputThrough(ctx, &http.Client{Transport: transport})
+}
+
+func (p *xrayProber) Close() error {
+	for _, transport := range p.transports {
+		transport.CloseIdleConnections()
+	}
+
+	return p.xray.Stop()
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// noiseSender sends UDP noise the way Xray's freedom outbound does: before
+// the first packet of a connection, every noise packet is written and then
+// followed by a random delay from its delay range.
+type noiseSender struct {
+	packet   []byte
+	minLen   int
+	maxLen   int
+	minDelay int
+	maxDelay int
+	count    int
+}
+
+// parseRange parses a fixed number or an interval like 50-100.
+func parseRange(value string) (int, int, error) {
+	if !isValidRange(value) {
+		return 0, 0, fmt.Errorf("invalid range %q", value)
+	}
+
+	low, high, found := strings.Cut(value, "-")
+	if !found {
+		high = low
+	}
+	from, _ := strconv.Atoi(low)
+	to, _ := strconv.Atoi(high)
+	return from, to, nil
+}
+
+func newNoiseSender(noise Noise) (*noiseSender, error) {
+	sender := &noiseSender{count: noise.Count}
+
+	var err error
+	switch noise.Type {
+	case "base64":
+		sender.packet, err = base64.StdEncoding.DecodeString(noise.Packet)
+	case "hex":
+		sender.packet, err = hex.DecodeString(noise.Packet)
+	case "str":
+		sender.packet = []byte(noise.Packet)
+	case "rand":
+		sender.minLen, sender.maxLen, err = parseRange(noise.Packet)
+	default:
+		err = fmt.Errorf("unknown noise type %q", noise.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid noise packet: %w", err)
+	}
+
+	if sender.minDelay, sender.maxDelay, err = parseRange(noise.Delay); err != nil {
+		return nil, fmt.Errorf("invalid noise delay: %w", err)
+	}
+
+	return sender, nil
+}
+
+func randomInRange(from, to int) int {
+	return from + mathrand.Intn(to-from+1)
+}
+
+// next returns a noise packet and the delay to wait after sending it.
+func (n *noiseSender) next() ([]byte, time.Duration) {
+	packet := n.packet
+	if packet == nil {
+		packet = make([]byte, randomInRange(n.minLen, n.maxLen))
+		rand.Read(packet)
+	}
+
+	return packet, time.Duration(randomInRange(n.minDelay, n.maxDelay)) * time.Millisecond
+}
+
+// Send writes count noise packets to conn, a nil sender sends nothing.
+func (n *noiseSender) Send(conn net.Conn) error {
+	if n == nil {
+		return nil
+	}
+
+	for range n.count {
+		packet, delay := n.next()
+		if _, err := conn.Write(packet); err != nil {
+			return fmt.Errorf("error sending noise: %w", socketError(err))
+		}
+		time.Sleep(delay)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNoiseSenderPackets(t *testing.T) {
+	tests := []struct {
+		noise Noise
+		want  []byte
+	}{
+		{Noise{Type: "base64", Packet: "aGVsbG8=", Delay: "1"}, []byte("hello")},
+		{Noise{Type: "hex", Packet: "deadbeef", Delay: "1"}, []byte{0xde, 0xad, 0xbe, 0xef}},
+		{Noise{Type: "str", Packet: "GET / HTTP/1.1", Delay: "1"}, []byte("GET / HTTP/1.1")},
+	}
+
+	for _, tt := range tests {
+		sender, err := newNoiseSender(tt.noise)
+		if err != nil {
+			t.Fatalf("newNoiseSender(%+v): %v", tt.noise, err)
+		}
+
+		packet, delay := sender.next()
+		if !bytes.Equal(packet, tt.want) {
+			t.Errorf("%s packet = %x, want %x", tt.noise.Type, packet, tt.want)
+		}
+		if delay != time.Millisecond {
+			t.Errorf("%s delay = %s, want 1ms", tt.noise.Type, delay)
+		}
+	}
+}
+
+func TestNoiseSenderRanges(t *testing.T) {
+	sender, err := newNoiseSender(Noise{Type: "rand", Packet: "10-20", Delay: "5-8"})
+	if err != nil {
+		t.Fatalf("newNoiseSender: %v", err)
+	}
+
+	for range 200 {
+		packet, delay := sender.next()
+		if len(packet) < 10 || len(packet) > 20 {
+			t.Fatalf("packet length %d, want 10-20", len(packet))
+		}
+		if delay < 5*time.Millisecond || delay > 8*time.Millisecond {
+			t.Fatalf("delay %s, want 5-8ms", delay)
+		}
+	}
+}
+
+func TestNoiseSenderInvalid(t *testing.T) {
+	for _, noise := range []Noise{
+		{Type: "base64", Packet: "not base64!", Delay: "1"},
+		{Type: "hex", Packet: "xyz", Delay: "1"},
+		{Type: "rand", Packet: "20-10", Delay: "1"},
+		{Type: "str", Packet: "x", Delay: "fast"},
+		{Type: "udp", Packet: "x", Delay: "1"},
+	} {
+		if _, err := newNoiseSender(noise); err == nil {
+			t.Errorf("newNoiseSender(%+v) succeeded, want an error", noise)
+		}
+	}
+}
+
+// countingConn counts the packets written to it.
+type countingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+func TestNoiseSenderSend(t *testing.T) {
+	sender, err := newNoiseSender(Noise{Type: "str", Packet: "x", Delay: "1", Count: 3})
+	if err != nil {
+		t.Fatalf("newNoiseSender: %v", err)
+	}
+
+	conn := &countingConn{}
+	if err := sender.Send(conn); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if conn.writes != 3 {
+		t.Errorf("sent %d packets, want 3", conn.writes)
+	}
+
+	var none *noiseSender
+	conn = &countingConn{}
+	if err := none.Send(conn); err != nil {
+		t.Fatalf("nil sender Send: %v", err)
+	}
+	if conn.writes != 0 {
+		t.Errorf("nil sender sent %d packets, want 0", conn.writes)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonResult is the machine-readable form of ScanResult with raw numeric
+// values, latencies are in milliseconds and loss is a percentage.
+type jsonResult struct {
+	Endpoint        string        `json:"endpoint"`
+	Host            string        `json:"host"`
+	Port            int           `json:"port"`
+	IPVersion       int           `json:"ipVersion"`
+	Score           float64       `json:"score,omitempty"`
+	Round           int           `json:"round,omitempty"`
+	LossPercent     float64       `json:"lossPercent"`
+	AvgLatencyMs    int64         `json:"avgLatencyMs"`
+	MinLatencyMs    int64         `json:"minLatencyMs"`
+	MaxLatencyMs    int64         `json:"maxLatencyMs"`
+	MedianLatencyMs float64       `json:"medianLatencyMs"`
+	P90LatencyMs    int64         `json:"p90LatencyMs"`
+	StdDevMs        float64       `json:"stdDevMs"`
+	JitterMs        float64       `json:"jitterMs"`
+	LatenciesMs     []int64       `json:"latenciesMs"`
+	Attempts        int           `json:"attempts"`
+	Successes       int           `json:"successes"`
+	StartedAt       time.Time     `json:"startedAt"`
+	FinishedAt      time.Time     `json:"finishedAt"`
+	Noise           *NoiseProfile `json:"noise"`
+	Errors          []string      `json:"errors,omitempty"`
+	DownloadMbps    *float64      `json:"downloadMbps,omitempty"`
+	UploadMbps      *float64      `json:"uploadMbps,omitempty"`
+	ThroughputError string        `json:"throughputError,omitempty"`
+}
+
+func newJsonResult(r ScanResult) jsonResult {
+	result := jsonResult{
+		Endpoint:        r.Endpoint,
+		Host:            r.Host,
+		Port:            r.Port,
+		IPVersion:       r.IPVersion,
+		Score:           r.Score,
+		Round:           r.Round,
+		LossPercent:     r.Loss,
+		AvgLatencyMs:    r.Latency,
+		MinLatencyMs:    r.MinLatency,
+		MaxLatencyMs:    r.MaxLatency,
+		MedianLatencyMs: r.MedianLatency,
+		P90LatencyMs:    r.P90Latency,
+		StdDevMs:        r.StdDev,
+		JitterMs:        r.Jitter,
+		LatenciesMs:     r.Latencies,
+		Attempts:        r.Attempts,
+		Successes:       r.Successes,
+		StartedAt:       r.StartedAt,
+		FinishedAt:      r.FinishedAt,
+		Errors:          r.Errors,
+	}
+
+	if scanConfig.UseNoise {
+		result.Noise = &NoiseProfile{
+			Enabled: true,
+			Type:    scanConfig.UdpNoise.Type,
+			Packet:  scanConfig.UdpNoise.Packet,
+			Delay:   scanConfig.UdpNoise.Delay,
+			Count:   scanConfig.UdpNoise.Count,
+		}
+	}
+
+	if r.Throughput != nil {
+		result.DownloadMbps = &r.Throughput.DownloadMbps
+		result.UploadMbps = &r.Throughput.UploadMbps
+		result.ThroughputError = r.Throughput.Error
+	}
+
+	return result
+}
+
+func writeCsv(path string, results []ScanResult) error {
+	lines := make([]string, 0, len(results)+1)
+	lines = append(lines, "Endpoint,Host,Port,IP version,Score,Loss rate (%),Avg. Latency (ms),Min. Latency (ms),Max. Latency (ms),Median Latency (ms),P90 Latency (ms),Std. Dev. (ms),Jitter (ms),Latencies (ms),Attempts,Successes,Download (Mbps),Upload (Mbps)")
+	for _, r := range results {
+		var download, upload string
+		if r.Throughput != nil {
+			download = strconv.FormatFloat(r.Throughput.DownloadMbps, 'f', 2, 64)
+			upload = strconv.FormatFloat(r.Throughput.UploadMbps, 'f', 2, 64)
+		}
+		latencies := make([]string, 0, len(r.Latencies))
+		for _, l := range r.Latencies {
+			latencies = append(latencies, strconv.FormatInt(l, 10))
+		}
+		lines = append(lines, fmt.Sprintf("%s,%s,%d,%d,%s,%s,%d,%d,%d,%s,%d,%s,%s,%s,%d,%d,%s,%s",
+			r.Endpoint, r.Host, r.Port, r.IPVersion,
+			strconv.FormatFloat(r.Score, 'f', 2, 64),
+			strconv.FormatFloat(r.Loss, 'f', 2, 64),
+			r.Latency, r.MinLatency, r.MaxLatency,
+			strconv.FormatFloat(r.MedianLatency, 'f', 1, 64),
+			r.P90Latency,
+			strconv.FormatFloat(r.StdDev, 'f', 2, 64),
+			strconv.FormatFloat(r.Jitter, 'f', 2, 64),
+			strings.Join(latencies, ";"),
+			r.Attempts, r.Successes,
+			download, upload,
+		))
+	}
+
+	return writeLines(path, lines)
+}
+
+func writeJson(path string, results []ScanResult) error {
+	jsonResults := make([]jsonResult, 0, len(results))
+	for _, r := range results {
+		jsonResults = append(jsonResults, newJsonResult(r))
+	}
+
+	jsonBytes, err := json.MarshalIndent(jsonResults, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+
+	return os.WriteFile(path, jsonBytes, 0644)
+}
+
+func writeJsonLines(path string, results []ScanResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, r := range results {
+		if err := encoder.Encode(newJsonResult(r)); err != nil {
+			return fmt.Errorf("json marshal error: %w", err)
+		}
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Attempt is the outcome of a single probe, Err is nil on success.
+type Attempt struct {
+	Latency time.Duration
+	Err     error
+}
+
+// Prober sends single probes to endpoints. Scheduling, retries and
+// aggregation are left to the scanner, so every engine is measured the
+// same way.
+type Prober interface {
+	Probe(ctx context.Context, endpoint string) Attempt
+	Close() error
+}
+
+// failureExplainer is implemented by probers that know more about a failed
+// endpoint than its attempt errors, like the Xray log lines of its outbound.
+type failureExplainer interface {
+	explain(endpoint string) []string
+}
+
+// newProber starts the engine selected by the scan config for a batch of
+// endpoints. offset is the number of endpoints scanned before the batch.
+func newProber(endpoints []string, offset int, params WarpParams) (Prober, error) {
+	switch scanConfig.Engine {
+	case "native":
+		return newNativeProber(params)
+	default:
+		return newXrayProber(endpoints, offset, params)
+	}
+}
+
+// startProber starts the prober of each batch, tests replace it with a fake.
+var startProber = newProber
+
+// rateLimiter spaces out probes across all workers, a nil limiter does not
+// limit at all.
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{ticker: time.NewTicker(time.Second / time.Duration(perSecond))}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.ticker.C:
+		return nil
+	}
+}
+
+func (l *rateLimiter) Stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
+
+// progress counts the endpoints measured by the running scan, for reporting
+// it while the scan goes on.
+type progress struct {
+	round     atomic.Int64
+	total     atomic.Int64
+	done      atomic.Int64
+	successes atomic.Int64
+}
+
+var scanProgress progress
+
+// start resets the counters for a pass over total endpoints.
+func (p *progress) start(round, total int) {
+	p.round.Store(int64(round))
+	p.total.Store(int64(total))
+	p.done.Store(0)
+	p.successes.Store(0)
+}
+
+func (p *progress) add(result ScanResult) {
+	p.done.Add(1)
+	if result.Successes > 0 {
+		p.successes.Add(1)
+	}
+}
+
+// runProbes measures the endpoints with a pool of Concurrency workers and
+// logs every result. retries gives the number of probes per endpoint and
+// offset is the number of endpoints scanned before, so log lines keep
+// numbering across batches. Endpoints not started before ctx is done are
+// left out of the results.
+func runProbes(ctx context.Context, prober Prober, endpoints []string, offset int, retries func(string) int, limiter *rateLimiter) []ScanResult {
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	results := make(chan ScanResult, len(endpoints))
+
+	workers := min(max(scanConfig.Concurrency, 1), len(endpoints))
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				result := measureEndpoint(ctx, prober, endpoints[j], retries(endpoints[j]), limiter)
+				logResult(offset+j, result)
+				scanProgress.add(result)
+				results <- result
+			}
+		}()
+	}
+
+feed:
+	for j := range endpoints {
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	var allResults []ScanResult
+	for r := range results {
+		allResults = append(allResults, r)
+	}
+
+	return allResults
+}
+
+// configuredRetries returns the retries set for the IP version of endpoint.
+func configuredRetries(endpoint string) int {
+	if isIPv6Endpoint(endpoint) {
+		return scanConfig.IPv6Retries
+	}
+
+	return scanConfig.IPv4Retries
+}
+
+// measureEndpoint sends currentRetries probes to endpoint, staggered by
+// RetryStaggeringMs and spaced out by the rate limiter.
+func measureEndpoint(ctx context.Context, prober Prober, endpoint string, currentRetries int, limiter *rateLimiter) ScanResult {
+	startedAt := time.Now()
+	var wg sync.WaitGroup
+	attempts := make([]Attempt, currentRetries)
+
+	for t := range currentRetries {
+		wg.Add(1)
+		go func(delay time.Duration) {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				attempts[t] = Attempt{Err: ctx.Err()}
+				return
+			case <-time.After(delay):
+			}
+
+			if err := limiter.Wait(ctx); err != nil {
+				attempts[t] = Attempt{Err: err}
+				return
+			}
+			attempts[t] = prober.Probe(ctx, endpoint)
+			recordProbe(attempts[t])
+		}(time.Duration(t*scanConfig.RetryStaggeringMs) * time.Millisecond)
+	}
+	wg.Wait()
+
+	result := aggregateAttempts(endpoint, attempts)
+	result.StartedAt = startedAt
+	result.FinishedAt = time.Now()
+
+	if explainer, ok := prober.(failureExplainer); ok && result.Successes == 0 {
+		if lines := explainer.explain(endpoint); len(lines) > 0 {
+			result.Errors = lines
+		}
+	}
+
+	return result
+}
+
+// aggregateAttempts summarizes the attempts of an endpoint. A result without
+// successes means the endpoint failed, its distinct attempt errors are kept
+// to explain why.
+func aggregateAttempts(endpoint string, attempts []Attempt) ScanResult {
+	result := newScanResult(endpoint)
+	var errs []string
+
+	for _, a := range attempts {
+		if a.Err != nil {
+			if !slices.Contains(errs, a.Err.Error()) && len(errs) < maxEndpointErrors {
+				errs = append(errs, a.Err.Error())
+			}
+			continue
+		}
+		result.Latencies = append(result.Latencies, a.Latency.Milliseconds())
+	}
+
+	result.Attempts = len(attempts)
+	result.Successes = len(result.Latencies)
+	if len(attempts) > 0 {
+		result.Loss = float64(len(attempts)-result.Successes) / float64(len(attempts)) * 100
+	}
+	if result.Successes > 0 {
+		computeLatencyStats(&result)
+	} else {
+		result.Errors = errs
+	}
+
+	return result
+}
+
+// rankResults returns the working endpoints within the loss and latency
+// filters, scored and sorted by the SortBy field. Endpoints that reached a
+// later tournament round come first, ties are broken by loss and then
+// average latency.
+func rankResults(results []ScanResult) []ScanResult {
+	working := slices.DeleteFunc(slices.Clone(results), func(r ScanResult) bool {
+		return r.Successes == 0 || !passesFilters(r)
+	})
+	scoreResults(working)
+	sortResults(working)
+
+	return working
+}
+
+// sortResults sorts scored results the way rankResults does.
+func sortResults(results []ScanResult) {
+	key := sortKeys[scanConfig.SortBy]
+	if key == nil {
+		key = sortKeys["score"]
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Round != b.Round {
+			return a.Round > b.Round
+		}
+		if key(a) != key(b) {
+			return key(a) < key(b)
+		}
+		if a.Loss != b.Loss {
+			return a.Loss < b.Loss
+		}
+		return a.Latency < b.Latency
+	})
+}
+
+// logResult prints the outcome of endpoint i and records it in the scanner
+// log, failures are explained by their first error if any.
+func logResult(i int, result ScanResult) {
+	if result.Successes == 0 {
+		scanLogger.Warn("endpoint failed", "endpoint", result.Endpoint, "attempts", result.Attempts, "errors", result.Errors)
+		reason := ""
+		if len(result.Errors) > 0 {
+			reason = " - " + result.Errors[0]
+		}
+		log.Printf("[%d] %s -> %s%s\n", i+1, fmtStr(result.Endpoint, ORANGE, false), fmtStr("Failed", RED, true), reason)
+		return
+	}
+
+	scanLogger.Info("endpoint passed", "endpoint", result.Endpoint, "loss", result.Loss, "latency", result.Latency)
+	log.Printf("[%d] %s -> %s - %s %.1f %% - %s %d ms\n",
+		i+1,
+		fmtStr(result.Endpoint, ORANGE, false),
+		fmtStr("Success", GREEN, true),
+		fmtStr("Loss rate:", "", true),
+		result.Loss,
+		fmtStr("Avg. Latency:", "", true),
+		result.Latency,
+	)
+}
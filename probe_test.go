@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// setScanConfig replaces the scan config for the duration of a test.
+func setScanConfig(t *testing.T, config ScanConfig) {
+	t.Helper()
+
+	saved := scanConfig
+	scanConfig = config
+	t.Cleanup(func() { scanConfig = saved })
+}
+
+func testScanConfig() ScanConfig {
+	config := scanConfig
+	config.Concurrency = 2
+	config.RetryStaggeringMs = 0
+	config.SortBy = "score"
+	config.Weights = defaultScoreWeights
+	config.MaxLoss = 100
+	config.MaxLatency = 0
+	return config
+}
+
+func succeeded(ms int) Attempt {
+	return Attempt{Latency: time.Duration(ms) * time.Millisecond}
+}
+
+func failed(err error) Attempt {
+	return Attempt{Err: err}
+}
+
+func TestAggregateAttempts(t *testing.T) {
+	r := aggregateAttempts("10.0.0.1:2408", []Attempt{succeeded(100), failed(errFakeTimeout), succeeded(120), failed(errFakeTimeout)})
+	if r.Attempts != 4 || r.Successes != 2 || r.Loss != 50 {
+		t.Errorf("got %d attempts, %d successes, %g %% loss, want 4, 2, 50 %%", r.Attempts, r.Successes, r.Loss)
+	}
+	if !slices.Equal(r.Latencies, []int64{100, 120}) || r.Latency != 110 {
+		t.Errorf("got latencies %v averaging %d ms, want [100 120] averaging 110 ms", r.Latencies, r.Latency)
+	}
+	if r.Errors != nil {
+		t.Errorf("working endpoint kept errors %v", r.Errors)
+	}
+
+	errRefused := errors.New("connection refused")
+	r = aggregateAttempts("10.0.0.2:2408", []Attempt{failed(errFakeTimeout), failed(errRefused), failed(errFakeTimeout)})
+	if r.Successes != 0 || r.Loss != 100 {
+		t.Errorf("got %d successes, %g %% loss, want 0, 100 %%", r.Successes, r.Loss)
+	}
+	if want := []string{errFakeTimeout.Error(), errRefused.Error()}; !slices.Equal(r.Errors, want) {
+		t.Errorf("got errors %q, want %q", r.Errors, want)
+	}
+
+	r = aggregateAttempts("10.0.0.3:2408", nil)
+	if r.Attempts != 0 || r.Loss != 0 {
+		t.Errorf("got %d attempts, %g %% loss without attempts, want 0, 0 %%", r.Attempts, r.Loss)
+	}
+}
+
+func TestRunProbes(t *testing.T) {
+	setScanConfig(t, testScanConfig())
+
+	var endpoints []string
+	script := make(map[string][]Attempt)
+	retries := make(map[string]int)
+	for i := range 6 {
+		endpoint := fmt.Sprintf("10.0.0.%d:2408", i+1)
+		endpoints = append(endpoints, endpoint)
+		retries[endpoint] = i + 1
+		for range i + 1 {
+			script[endpoint] = append(script[endpoint], succeeded(50))
+		}
+	}
+
+	prober := newFakeProber(script)
+	prober.delay = 20 * time.Millisecond
+	results := runProbes(context.Background(), prober, endpoints, 0, func(endpoint string) int { return retries[endpoint] }, nil)
+
+	if len(results) != len(endpoints) {
+		t.Fatalf("got %d results, want %d", len(results), len(endpoints))
+	}
+	for _, r := range results {
+		want := retries[r.Endpoint]
+		if r.Attempts != want || r.Successes != want || prober.calls[r.Endpoint] != want {
+			t.Errorf("%s: %d attempts, %d successes, %d probes, want %d of each", r.Endpoint, r.Attempts, r.Successes, prober.calls[r.Endpoint], want)
+		}
+	}
+
+	// Two workers measure one endpoint each, with all its retries at once.
+	if limit := retries[endpoints[4]] + retries[endpoints[5]]; prober.maxInFlight > limit {
+		t.Errorf("%d probes ran at once, want at most %d", prober.maxInFlight, limit)
+	}
+}
+
+func TestRankResults(t *testing.T) {
+	config := testScanConfig()
+	config.MaxLatency = 250
+	setScanConfig(t, config)
+
+	script := map[string][]Attempt{
+		"10.0.0.1:2408": {succeeded(100), succeeded(100), succeeded(100), succeeded(100)},
+		"10.0.0.2:2408": {succeeded(50), succeeded(50), succeeded(50), failed(errFakeTimeout)},
+		"10.0.0.3:2408": {succeeded(80), succeeded(80), succeeded(80), succeeded(80)},
+		"10.0.0.4:2408": {failed(errFakeTimeout), failed(errFakeTimeout), failed(errFakeTimeout), failed(errFakeTimeout)},
+		"10.0.0.5:2408": {succeeded(300), succeeded(300), succeeded(300), succeeded(300)},
+	}
+	endpoints := slices.Sorted(maps.Keys(script))
+	results := runProbes(context.Background(), newFakeProber(script), endpoints, 0, func(string) int { return 4 }, nil)
+
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		// 10.0.0.2 is the fastest, but losing a quarter of its probes
+		// ranks it below both lossless endpoints.
+		{"score", []string{"10.0.0.3:2408", "10.0.0.1:2408", "10.0.0.2:2408"}},
+		{"latency", []string{"10.0.0.2:2408", "10.0.0.3:2408", "10.0.0.1:2408"}},
+		{"loss", []string{"10.0.0.3:2408", "10.0.0.1:2408", "10.0.0.2:2408"}},
+	}
+	for _, tt := range tests {
+		scanConfig.SortBy = tt.sortBy
+		var got []string
+		for _, r := range rankResults(results) {
+			got = append(got, r.Endpoint)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("sorted by %s: got %v, want %v", tt.sortBy, got, tt.want)
+		}
+	}
+
+	// Endpoints that reached a later tournament round rank first.
+	scanConfig.SortBy = "score"
+	for i := range results {
+		if results[i].Endpoint == "10.0.0.1:2408" {
+			results[i].Round = 2
+		}
+	}
+	if ranked := rankResults(results); ranked[0].Endpoint != "10.0.0.1:2408" {
+		t.Errorf("got %s first, want the finalist 10.0.0.1:2408", ranked[0].Endpoint)
+	}
+}
+
+// TestSortResultsWithThroughput ranks results after a throughput stage: with
+// a non-score key throughput does not change the order, with the score key
+// the Score column stays monotonic across tested and untested endpoints.
+func TestSortResultsWithThroughput(t *testing.T) {
+	setScanConfig(t, testScanConfig())
+
+	results := []ScanResult{
+		aggregateAttempts("10.0.0.1:2408", []Attempt{succeeded(50), succeeded(50)}),
+		aggregateAttempts("10.0.0.2:2408", []Attempt{succeeded(60), succeeded(60)}),
+		aggregateAttempts("10.0.0.3:2408", []Attempt{succeeded(70), succeeded(70)}),
+	}
+	results[0].Throughput = &Throughput{DownloadMbps: 1}
+	results[1].Throughput = &Throughput{DownloadMbps: 100}
+
+	scanConfig.SortBy = "latency"
+	scoreResults(results)
+	sortResults(results)
+	for i, want := range []string{"10.0.0.1:2408", "10.0.0.2:2408", "10.0.0.3:2408"} {
+		if results[i].Endpoint != want {
+			t.Errorf("sorted by latency: got %s at %d, want %s", results[i].Endpoint, i, want)
+		}
+	}
+
+	scanConfig.SortBy = "score"
+	sortResults(results)
+	if !slices.IsSortedFunc(results, func(a, b ScanResult) int { return cmp.Compare(b.Score, a.Score) }) {
+		t.Errorf("sorted by score, scores are not descending: %v", results)
+	}
+	if results[0].Endpoint != "10.0.0.2:2408" {
+		t.Errorf("got %s first, want the fastest download 10.0.0.2:2408", results[0].Endpoint)
+	}
+}
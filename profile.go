@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+)
+
+type NoiseProfile struct {
+	Enabled bool   `json:"enabled"`
+	Type    string `json:"type"`
+	Packet  string `json:"packet"`
+	Delay   string `json:"delay"`
+	Count   int    `json:"count"`
+}
+
+// Profile is the on-disk form of ScanConfig, so a scan can be reproduced
+// with the same answers on another machine.
+type Profile struct {
+	EndpointCount     int           `json:"endpointCount"`
+	IPv4              bool          `json:"ipv4"`
+	IPv6              bool          `json:"ipv6"`
+	IPv4Retries       int           `json:"ipv4Retries"`
+	IPv6Retries       int           `json:"ipv6Retries"`
+	RetryStaggeringMs int           `json:"retryStaggeringMs"`
+	Concurrency       int           `json:"concurrency"`
+	ProbesPerSecond   int           `json:"probesPerSecond"`
+	Noise             NoiseProfile  `json:"noise"`
+	OutputCount       int           `json:"outputCount"`
+	Ports             []int         `json:"ports"`
+	Cidrs             []string      `json:"cidrs"`
+	EndpointsFile     string        `json:"endpointsFile,omitempty"`
+	Exhaustive        bool          `json:"exhaustive"`
+	Seed              int64         `json:"seed"`
+	BatchSize         int           `json:"batchSize"`
+	Engine            string        `json:"engine"`
+	ThroughputCount   int           `json:"throughputCount"`
+	ThroughputURL     string        `json:"throughputUrl"`
+	UploadURL         string        `json:"uploadUrl"`
+	Targets           []ProbeTarget `json:"targets"`
+	TargetMode        string        `json:"targetMode"`
+	SortBy            string        `json:"sortBy"`
+	Weights           ScoreWeights  `json:"weights"`
+	MaxLoss           float64       `json:"maxLoss"`
+	MaxLatency        int64         `json:"maxLatency"`
+	Rounds            []int         `json:"rounds"`
+	SurvivorPercent   int           `json:"survivorPercent"`
+}
+
+var profileLoaded bool
+
+func newProfile(config ScanConfig) Profile {
+	return Profile{
+		EndpointCount:     config.EndpointCount,
+		IPv4:              config.Ipv4Mode,
+		IPv6:              config.Ipv6Mode,
+		IPv4Retries:       config.IPv4Retries,
+		IPv6Retries:       config.IPv6Retries,
+		RetryStaggeringMs: config.RetryStaggeringMs,
+		Concurrency:       config.Concurrency,
+		ProbesPerSecond:   config.ProbesPerSecond,
+		Noise: NoiseProfile{
+			Enabled: config.UseNoise,
+			Type:    config.UdpNoise.Type,
+			Packet:  config.UdpNoise.Packet,
+			Delay:   config.UdpNoise.Delay,
+			Count:   config.UdpNoise.Count,
+		},
+		OutputCount:     config.OutputCount,
+		Ports:           slices.Clone(config.Ports),
+		Cidrs:           slices.Clone(config.Cidrs),
+		EndpointsFile:   config.EndpointsFile,
+		Exhaustive:      config.Exhaustive,
+		Seed:            config.Seed,
+		BatchSize:       config.BatchSize,
+		Engine:          config.Engine,
+		ThroughputCount: config.ThroughputCount,
+		ThroughputURL:   config.ThroughputURL,
+		UploadURL:       config.UploadURL,
+		Targets:         slices.Clone(config.Targets),
+		TargetMode:      config.TargetMode,
+		SortBy:          config.SortBy,
+		Weights:         config.Weights,
+		MaxLoss:         config.MaxLoss,
+		MaxLatency:      config.MaxLatency,
+		Rounds:          slices.Clone(config.Rounds),
+		SurvivorPercent: config.SurvivorPercent,
+	}
+}
+
+func (p Profile) scanConfig() (ScanConfig, error) {
+	if isValid, _ := checkNum(strconv.Itoa(p.EndpointCount), 1, 10000); !isValid {
+		return ScanConfig{}, fmt.Errorf("endpointCount must be between 1-10000")
+	}
+
+	if !p.IPv4 && !p.IPv6 {
+		return ScanConfig{}, fmt.Errorf("at least one of ipv4 or ipv6 must be enabled")
+	}
+
+	for name, retries := range map[string]int{"ipv4Retries": p.IPv4Retries, "ipv6Retries": p.IPv6Retries} {
+		if isValid, _ := checkNum(strconv.Itoa(retries), 1, 50); !isValid {
+			return ScanConfig{}, fmt.Errorf("%s must be between 1-50", name)
+		}
+	}
+
+	if p.RetryStaggeringMs < 0 {
+		return ScanConfig{}, fmt.Errorf("retryStaggeringMs can not be negative")
+	}
+
+	if isValid, _ := checkNum(strconv.Itoa(p.Concurrency), 1, 1000); !isValid {
+		return ScanConfig{}, fmt.Errorf("concurrency must be between 1-1000")
+	}
+
+	if isValid, _ := checkNum(strconv.Itoa(p.ProbesPerSecond), 0, 10000); !isValid {
+		return ScanConfig{}, fmt.Errorf("probesPerSecond must be between 0-10000, use 0 for no limit")
+	}
+
+	if isValid, _ := checkNum(strconv.Itoa(p.BatchSize), 1, 5000); !isValid {
+		return ScanConfig{}, fmt.Errorf("batchSize must be between 1-5000")
+	}
+
+	if err := validateEngine(p.Engine); err != nil {
+		return ScanConfig{}, err
+	}
+
+	if p.ThroughputCount < 0 {
+		return ScanConfig{}, fmt.Errorf("throughputCount can not be negative, use 0 to skip the throughput test")
+	}
+	if p.ThroughputCount > 0 {
+		if err := validateThroughputURL("throughputUrl", p.ThroughputURL, true); err != nil {
+			return ScanConfig{}, err
+		}
+		if err := validateThroughputURL("uploadUrl", p.UploadURL, false); err != nil {
+			return ScanConfig{}, err
+		}
+	}
+
+	if err := validateTargets(p.Targets, p.TargetMode); err != nil {
+		return ScanConfig{}, err
+	}
+
+	if err := validateSortKey(p.SortBy); err != nil {
+		return ScanConfig{}, err
+	}
+
+	if err := validateWeights(p.Weights); err != nil {
+		return ScanConfig{}, err
+	}
+
+	if err := validateFilters(p.MaxLoss, p.MaxLatency); err != nil {
+		return ScanConfig{}, err
+	}
+
+	if err := validateRounds(p.Rounds, p.SurvivorPercent); err != nil {
+		return ScanConfig{}, err
+	}
+
+	noise := Noise{
+		Type:   p.Noise.Type,
+		Packet: p.Noise.Packet,
+		Delay:  p.Noise.Delay,
+		Count:  p.Noise.Count,
+	}
+	if p.Noise.Enabled {
+		if err := validateNoise(noise); err != nil {
+			return ScanConfig{}, err
+		}
+	}
+
+	if p.OutputCount != 0 {
+		if isValid, _ := checkNum(strconv.Itoa(p.OutputCount), 1, p.EndpointCount); !isValid {
+			return ScanConfig{}, fmt.Errorf("outputCount must be between 1-%d", p.EndpointCount)
+		}
+	}
+
+	config := ScanConfig{
+		EndpointCount:     p.EndpointCount,
+		Ipv4Mode:          p.IPv4,
+		Ipv6Mode:          p.IPv6,
+		IPv4Retries:       p.IPv4Retries,
+		IPv6Retries:       p.IPv6Retries,
+		RetryStaggeringMs: p.RetryStaggeringMs,
+		Concurrency:       p.Concurrency,
+		ProbesPerSecond:   p.ProbesPerSecond,
+		UseNoise:          p.Noise.Enabled,
+		UdpNoise:          noise,
+		OutputCount:       p.OutputCount,
+		Ports:             p.Ports,
+		Cidrs:             p.Cidrs,
+		EndpointsFile:     p.EndpointsFile,
+		Exhaustive:        p.Exhaustive,
+		Seed:              p.Seed,
+		BatchSize:         p.BatchSize,
+		Engine:            p.Engine,
+		ThroughputCount:   p.ThroughputCount,
+		ThroughputURL:     p.ThroughputURL,
+		UploadURL:         p.UploadURL,
+		Targets:           p.Targets,
+		TargetMode:        p.TargetMode,
+		SortBy:            p.SortBy,
+		Weights:           p.Weights,
+		MaxLoss:           p.MaxLoss,
+		MaxLatency:        p.MaxLatency,
+		Rounds:            p.Rounds,
+		SurvivorPercent:   p.SurvivorPercent,
+	}
+
+	if err := validateEndpointSources(config); err != nil {
+		return ScanConfig{}, err
+	}
+
+	return config, nil
+}
+
+// decodeProfile reads a profile over the values of base. Lists given in the
+// profile replace the defaults instead of being decoded into them, so their
+// entries never inherit fields of the default entries.
+func decodeProfile(data []byte, base ScanConfig) (Profile, error) {
+	profile := newProfile(base)
+	profile.Ports, profile.Cidrs, profile.Targets, profile.Rounds = nil, nil, nil, nil
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return Profile{}, err
+	}
+
+	if profile.Ports == nil {
+		profile.Ports = slices.Clone(base.Ports)
+	}
+	if profile.Cidrs == nil {
+		profile.Cidrs = slices.Clone(base.Cidrs)
+	}
+	if profile.Targets == nil {
+		profile.Targets = slices.Clone(base.Targets)
+	}
+	if profile.Rounds == nil {
+		profile.Rounds = slices.Clone(base.Rounds)
+	}
+	for i, target := range profile.Targets {
+		profile.Targets[i] = withDefaultMethod(target)
+	}
+
+	return profile, nil
+}
+
+// loadProfile reads a profile on top of the current scan config, so fields
+// missing from the file keep their default values.
+func loadProfile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading profile: %w", err)
+	}
+
+	profile, err := decodeProfile(data, scanConfig)
+	if err != nil {
+		return fmt.Errorf("error parsing profile %s: %w", path, err)
+	}
+
+	config, err := profile.scanConfig()
+	if err != nil {
+		return fmt.Errorf("invalid profile %s: %w", path, err)
+	}
+
+	scanConfig = config
+	profileLoaded = true
+	return nil
+}
+
+func saveProfile(path string) error {
+	jsonBytes, err := json.MarshalIndent(newProfile(scanConfig), "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("error writing profile: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ScoreWeights sets how much each part of an endpoint's quality counts in
+// its score. Only the ratios between weights matter.
+type ScoreWeights struct {
+	Loss       float64 `json:"loss"`
+	Latency    float64 `json:"latency"`
+	Jitter     float64 `json:"jitter"`
+	Throughput float64 `json:"throughput"`
+}
+
+var defaultScoreWeights = ScoreWeights{
+	Loss:       0.5,
+	Latency:    0.25,
+	Jitter:     0.1,
+	Throughput: 0.15,
+}
+
+// parseWeights parses weights like "loss=0.5,latency=0.5" on top of base,
+// so weights that are not given keep their value.
+func parseWeights(spec string, base ScoreWeights) (ScoreWeights, error) {
+	weights := base
+	for part := range strings.SplitSeq(spec, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return ScoreWeights{}, fmt.Errorf("invalid weight %q, expected name=value like loss=0.4", part)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return ScoreWeights{}, fmt.Errorf("invalid weight %q, %q is not a number", part, value)
+		}
+
+		switch strings.TrimSpace(name) {
+		case "loss":
+			weights.Loss = weight
+		case "latency":
+			weights.Latency = weight
+		case "jitter":
+			weights.Jitter = weight
+		case "throughput":
+			weights.Throughput = weight
+		default:
+			return ScoreWeights{}, fmt.Errorf("invalid weight name %q, please use loss, latency, jitter or throughput", name)
+		}
+	}
+
+	return weights, validateWeights(weights)
+}
+
+func validateWeights(w ScoreWeights) error {
+	if w.Loss < 0 || w.Latency < 0 || w.Jitter < 0 || w.Throughput < 0 {
+		return fmt.Errorf("score weights can not be negative")
+	}
+
+	if w.Loss+w.Latency+w.Jitter == 0 {
+		return fmt.Errorf("at least one of the loss, latency or jitter weights must be positive")
+	}
+
+	return nil
+}
+
+func validateFilters(maxLoss float64, maxLatency int64) error {
+	if maxLoss < 0 || maxLoss > 100 {
+		return fmt.Errorf("invalid max loss %g, it should be between 0-100 percent", maxLoss)
+	}
+
+	if maxLatency < 0 {
+		return fmt.Errorf("invalid max latency %d, it can not be negative, use 0 for no limit", maxLatency)
+	}
+
+	return nil
+}
+
+// passesFilters reports whether a working endpoint is within the MaxLoss
+// and MaxLatency limits of the scan.
+func passesFilters(r ScanResult) bool {
+	if r.Loss > scanConfig.MaxLoss {
+		return false
+	}
+
+	return scanConfig.MaxLatency == 0 || r.Latency <= scanConfig.MaxLatency
+}
+
+// scoreResults sets the score of every result, from 0 to 100. Latency,
+// jitter and throughput are rated against the best of the results, loss
+// against no loss at all. The loss rating is squared, so losing probes costs
+// more than being a little slower: a tunnel that drops packets is worse than
+// a slow one. Throughput only counts for tested endpoints, the others are
+// scored on the remaining weights.
+func scoreResults(results []ScanResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	bestLatency := slices.MinFunc(results, func(a, b ScanResult) int {
+		return cmp.Compare(a.Latency, b.Latency)
+	}).Latency
+	bestJitter := slices.MinFunc(results, func(a, b ScanResult) int {
+		return cmp.Compare(a.Jitter, b.Jitter)
+	}).Jitter
+
+	var bestMbps float64
+	for _, r := range results {
+		if r.Throughput != nil {
+			bestMbps = max(bestMbps, r.Throughput.DownloadMbps+r.Throughput.UploadMbps)
+		}
+	}
+
+	w := scanConfig.Weights
+	for i := range results {
+		r := &results[i]
+		delivered := 1 - r.Loss/100
+		total := w.Loss*delivered*delivered +
+			w.Latency*float64(max(bestLatency, 1))/float64(max(r.Latency, 1)) +
+			w.Jitter*(bestJitter+1)/(r.Jitter+1)
+		weights := w.Loss + w.Latency + w.Jitter
+
+		if r.Throughput != nil && bestMbps > 0 {
+			total += w.Throughput * (r.Throughput.DownloadMbps + r.Throughput.UploadMbps) / bestMbps
+			weights += w.Throughput
+		}
+
+		r.Score = total / weights * 100
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxProfileBytes = 1 << 20
+
+// apiScan is a scan started through the API. Its results are kept in their
+// JSON form, so later scans with other settings do not change them.
+type apiScan struct {
+	ID         string       `json:"id"`
+	Status     string       `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt *time.Time   `json:"finishedAt,omitempty"`
+	Round      int64        `json:"round"`
+	Total      int64        `json:"total"`
+	Done       int64        `json:"done"`
+	Successes  int64        `json:"successes"`
+	Working    int          `json:"working"`
+	Profile    Profile      `json:"profile"`
+	results    []jsonResult `json:"-"`
+	cancel     context.CancelFunc
+}
+
+// scanServer runs scans posted to the API one at a time, with the scan
+// config of the command line as defaults for every posted profile.
+type scanServer struct {
+	mu      sync.Mutex
+	base    ScanConfig
+	scans   []*apiScan
+	running *apiScan
+}
+
+// validateListenAddress only accepts loopback addresses, since the API has
+// no authentication.
+func validateListenAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid -listen %q, expected host:port like 127.0.0.1:8086", address)
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err != nil || !addr.IsLoopback() {
+		return fmt.Errorf("invalid -listen %q, only loopback addresses like 127.0.0.1 or [::1] are allowed", address)
+	}
+
+	return nil
+}
+
+// allowLocalHosts rejects requests whose Host header is not the listen
+// address or a loopback name with its port. Loopback listening alone does
+// not stop DNS rebinding, which makes a web page same-origin with the API.
+func allowLocalHosts(address string, next http.Handler) http.Handler {
+	_, port, _ := net.SplitHostPort(address)
+	allowed := map[string]bool{strings.ToLower(address): true}
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		allowed[net.JoinHostPort(host, port)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[strings.ToLower(r.Host)] {
+			writeAPIError(w, http.StatusForbidden, fmt.Sprintf("host %q is not allowed", r.Host))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runServer serves the scan API on -listen until ctx is done, then cancels
+// the running scan if any.
+func runServer(ctx context.Context) error {
+	s := &scanServer{base: scanConfig}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scans", s.startScan)
+	mux.HandleFunc("GET /scans", s.listScans)
+	mux.HandleFunc("GET /scans/{id}", s.getScan)
+	mux.HandleFunc("GET /scans/{id}/results", s.getResults)
+	mux.HandleFunc("POST /scans/{id}/cancel", s.cancelScan)
+	mux.HandleFunc("GET /metrics", metricsHandler)
+
+	server := &http.Server{
+		Addr:              *listenFlag,
+		Handler:           allowLocalHosts(*listenFlag, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		if s.running != nil {
+			s.running.cancel()
+		}
+		s.mu.Unlock()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	message := fmt.Sprintf("Serving the scan API on http://%s, press Ctrl+C to stop.", *listenFlag)
+	successMessage(message)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// startScan starts a scan of the posted profile. Profiles must be sent as
+// JSON, which browsers can not do cross-site without asking first, and can
+// not point the scanner at files of the machine.
+func (s *scanServer) startScan(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "profiles must be sent with Content-Type application/json")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxProfileBytes))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("error reading profile: %v", err))
+		return
+	}
+
+	profile := newProfile(s.base)
+	if len(body) > 0 {
+		if profile, err = decodeProfile(body, s.base); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("error parsing profile: %v", err))
+			return
+		}
+	}
+	if profile.EndpointsFile != s.base.EndpointsFile {
+		writeAPIError(w, http.StatusBadRequest, "endpointsFile can not be set through the API")
+		return
+	}
+
+	config, err := profile.scanConfig()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid profile: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != nil {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("scan %s is still running", s.running.ID))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scan := &apiScan{
+		ID:        strconv.Itoa(len(s.scans) + 1),
+		Status:    "running",
+		StartedAt: time.Now(),
+		Profile:   profile,
+		cancel:    cancel,
+	}
+	s.scans = append(s.scans, scan)
+	s.running = scan
+	scanProgress.start(0, 0)
+
+	go s.run(ctx, scan, config)
+	writeJSONResponse(w, http.StatusAccepted, s.snapshot(scan))
+}
+
+// run runs a scan like the command line does, without the network quality
+// check, outputs and exports.
+func (s *scanServer) run(ctx context.Context, scan *apiScan, config ScanConfig) {
+	defer scan.cancel()
+	scanLogger.Info("api scan started", "id", scan.ID)
+
+	scanConfig = config
+	ranked, all, err := func() ([]ScanResult, []ScanResult, error) {
+		if scanConfig.Engine == "xray" {
+			if err := prepareXrayCore(); err != nil {
+				return nil, nil, fmt.Errorf("xray core is missing or not executable: %w", err)
+			}
+		}
+
+		if err := generateEndpoints(); err != nil {
+			return nil, nil, err
+		}
+
+		scanned, err := scanEndpoints(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, nil, err
+		}
+
+		ranked, all := completeScan(ctx, scanned)
+		return ranked, all, nil
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*scan = s.snapshot(scan)
+	finishedAt := time.Now()
+	scan.FinishedAt = &finishedAt
+
+	switch {
+	case err != nil:
+		scan.Status = "failed"
+		scan.Error = err.Error()
+	case ctx.Err() != nil:
+		scan.Status = "cancelled"
+	default:
+		scan.Status = "completed"
+	}
+
+	scan.Working = len(ranked)
+	best := len(ranked)
+	if scanConfig.OutputCount > 0 {
+		best = min(scanConfig.OutputCount, best)
+	}
+	recordEndpoints(ranked[:best])
+	scan.results = make([]jsonResult, 0, len(all))
+	for _, r := range all {
+		scan.results = append(scan.results, newJsonResult(r))
+	}
+
+	s.running = nil
+	scanLogger.Info("api scan finished", "id", scan.ID, "status", scan.Status, "error", scan.Error)
+}
+
+// snapshot returns a copy of scan, with the live progress counters if it is
+// running. s.mu must be held.
+func (s *scanServer) snapshot(scan *apiScan) apiScan {
+	c := *scan
+	if scan == s.running {
+		c.Round = scanProgress.round.Load()
+		c.Total = scanProgress.total.Load()
+		c.Done = scanProgress.done.Load()
+		c.Successes = scanProgress.successes.Load()
+	}
+
+	return c
+}
+
+func (s *scanServer) find(id string) *apiScan {
+	for _, scan := range s.scans {
+		if scan.ID == id {
+			return scan
+		}
+	}
+
+	return nil
+}
+
+func (s *scanServer) listScans(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scans := make([]apiScan, 0, len(s.scans))
+	for _, scan := range s.scans {
+		scans = append(scans, s.snapshot(scan))
+	}
+	writeJSONResponse(w, http.StatusOK, scans)
+}
+
+func (s *scanServer) getScan(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scan := s.find(r.PathValue("id"))
+	if scan == nil {
+		writeAPIError(w, http.StatusNotFound, "scan not found")
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, s.snapshot(scan))
+}
+
+func (s *scanServer) getResults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scan := s.find(r.PathValue("id"))
+	switch {
+	case scan == nil:
+		writeAPIError(w, http.StatusNotFound, "scan not found")
+	case scan == s.running:
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("scan %s is still running", scan.ID))
+	default:
+		writeJSONResponse(w, http.StatusOK, scan.results)
+	}
+}
+
+func (s *scanServer) cancelScan(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scan := s.find(r.PathValue("id"))
+	switch {
+	case scan == nil:
+		writeAPIError(w, http.StatusNotFound, "scan not found")
+	case scan != s.running:
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("scan %s is not running", scan.ID))
+	default:
+		scan.cancel()
+		writeJSONResponse(w, http.StatusAccepted, s.snapshot(scan))
+	}
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, v any) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonBytes)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSONResponse(w, status, map[string]string{"error": message})
+}
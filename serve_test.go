@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateListenAddress(t *testing.T) {
+	for address, valid := range map[string]bool{
+		"127.0.0.1:8086": true,
+		"[::1]:8086":     true,
+		"localhost:8086": true,
+		"0.0.0.0:8086":   false,
+		":8086":          false,
+		"10.0.0.1:8086":  false,
+		"example.com:80": false,
+		"127.0.0.1":      false,
+	} {
+		if err := validateListenAddress(address); (err == nil) != valid {
+			t.Errorf("validateListenAddress(%q) = %v, want valid %t", address, err, valid)
+		}
+	}
+}
+
+func TestAllowLocalHosts(t *testing.T) {
+	handler := allowLocalHosts("127.0.0.1:8086", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for host, want := range map[string]int{
+		"127.0.0.1:8086":        http.StatusNoContent,
+		"localhost:8086":        http.StatusNoContent,
+		"LOCALHOST:8086":        http.StatusNoContent,
+		"[::1]:8086":            http.StatusNoContent,
+		"localhost:9000":        http.StatusForbidden,
+		"attacker.example:8086": http.StatusForbidden,
+		"127.0.0.1":             http.StatusForbidden,
+		"":                      http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/scans", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Host %q: got status %d, want %d", host, rec.Code, want)
+		}
+	}
+}
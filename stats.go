@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// sortKeys are the result fields endpoints can be ranked by, lower is better
+// for all of them, so the score is negated.
+var sortKeys = map[string]func(ScanResult) float64{
+	"score":   func(r ScanResult) float64 { return -r.Score },
+	"latency": func(r ScanResult) float64 { return float64(r.Latency) },
+	"loss":    func(r ScanResult) float64 { return r.Loss },
+	"min":     func(r ScanResult) float64 { return float64(r.MinLatency) },
+	"max":     func(r ScanResult) float64 { return float64(r.MaxLatency) },
+	"median":  func(r ScanResult) float64 { return r.MedianLatency },
+	"p90":     func(r ScanResult) float64 { return float64(r.P90Latency) },
+	"stddev":  func(r ScanResult) float64 { return r.StdDev },
+	"jitter":  func(r ScanResult) float64 { return r.Jitter },
+}
+
+func validateSortKey(key string) error {
+	if _, ok := sortKeys[key]; !ok {
+		keys := slices.Sorted(maps.Keys(sortKeys))
+		return fmt.Errorf("invalid sort key %q, please use one of %s", key, strings.Join(keys, ", "))
+	}
+
+	return nil
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// computeLatencyStats fills the latency statistics of a result from the
+// latencies of its successful attempts, kept in attempt order. Jitter is
+// the mean difference between consecutive attempts, like RTP jitter.
+func computeLatencyStats(r *ScanResult) {
+	n := len(r.Latencies)
+	if n == 0 {
+		return
+	}
+
+	sorted := slices.Sorted(slices.Values(r.Latencies))
+	var total int64
+	for _, l := range sorted {
+		total += l
+	}
+	mean := float64(total) / float64(n)
+
+	r.Latency = total / int64(n)
+	r.MinLatency = sorted[0]
+	r.MaxLatency = sorted[n-1]
+	r.P90Latency = percentile(sorted, 90)
+	if n%2 == 1 {
+		r.MedianLatency = float64(sorted[n/2])
+	} else {
+		r.MedianLatency = float64(sorted[n/2-1]+sorted[n/2]) / 2
+	}
+
+	var variance float64
+	for _, l := range sorted {
+		variance += (float64(l) - mean) * (float64(l) - mean)
+	}
+	r.StdDev = math.Sqrt(variance / float64(n))
+
+	if n > 1 {
+		var diffs int64
+		for i := 1; i < n; i++ {
+			diff := r.Latencies[i] - r.Latencies[i-1]
+			diffs += max(diff, -diff)
+		}
+		r.Jitter = float64(diffs) / float64(n-1)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxTargetBodyBytes bounds how much of a response is searched for the
+// expected body substring.
+const maxTargetBodyBytes = 64 << 10
+
+var (
+	errUnexpectedStatus = errors.New("unexpected HTTP status")
+	errBodyMismatch     = errors.New("response body mismatch")
+)
+
+// ProbeTarget is a URL probed through each tunnel. A probe passes when the
+// response has the expected status, any 2xx if none is set, and contains
+// BodyContains if given.
+type ProbeTarget struct {
+	URL            string `json:"url"`
+	Method         string `json:"method"`
+	ExpectedStatus int    `json:"expectedStatus"`
+	BodyContains   string `json:"bodyContains,omitempty"`
+}
+
+var defaultProbeTargets = []ProbeTarget{
+	{URL: "http://www.gstatic.com/generate_204", Method: http.MethodHead, ExpectedStatus: http.StatusNoContent},
+}
+
+// targetList collects repeated -target flags.
+type targetList []string
+
+func (l *targetList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *targetList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseTarget parses a target like "GET https://example.com/ 200 ok", only
+// the URL is required. The method defaults to HEAD, or GET when a body
+// substring is expected.
+func parseTarget(spec string) (ProbeTarget, error) {
+	fields := strings.Fields(spec)
+	var target ProbeTarget
+
+	if len(fields) > 0 && !strings.Contains(fields[0], "://") {
+		target.Method = strings.ToUpper(fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ProbeTarget{}, fmt.Errorf("invalid target %q, a URL is required", spec)
+	}
+	target.URL = fields[0]
+
+	if len(fields) > 1 {
+		status, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return ProbeTarget{}, fmt.Errorf("invalid target %q, expected status %q is not a number", spec, fields[1])
+		}
+		target.ExpectedStatus = status
+	}
+	if len(fields) > 2 {
+		target.BodyContains = strings.Join(fields[2:], " ")
+	}
+
+	target = withDefaultMethod(target)
+	return target, validateTarget(target)
+}
+
+// withDefaultMethod sets a missing method to HEAD, or GET when a body
+// substring is expected.
+func withDefaultMethod(target ProbeTarget) ProbeTarget {
+	if target.Method == "" {
+		target.Method = http.MethodHead
+		if target.BodyContains != "" {
+			target.Method = http.MethodGet
+		}
+	}
+
+	return target
+}
+
+func validateTarget(target ProbeTarget) error {
+	u, err := url.Parse(target.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid target URL %q, it should be an http or https URL", target.URL)
+	}
+
+	switch target.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		return fmt.Errorf("invalid target method %q, please use GET or HEAD", target.Method)
+	}
+
+	if target.ExpectedStatus != 0 && (target.ExpectedStatus < 100 || target.ExpectedStatus > 599) {
+		return fmt.Errorf("invalid expected status %d for target %s", target.ExpectedStatus, target.URL)
+	}
+
+	if target.BodyContains != "" && target.Method == http.MethodHead {
+		return fmt.Errorf("target %s expects a body, please use GET", target.URL)
+	}
+
+	return nil
+}
+
+func validateTargets(targets []ProbeTarget, mode string) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("at least one probe target is required")
+	}
+
+	for _, target := range targets {
+		if err := validateTarget(target); err != nil {
+			return err
+		}
+	}
+
+	switch mode {
+	case "round-robin", "all":
+		return nil
+	default:
+		return fmt.Errorf("invalid target mode %q, please use round-robin or all", mode)
+	}
+}
+
+// targetChecker checks the probe targets of a scan. In round-robin mode
+// every check uses the next target, in all mode every target must pass and
+// the latency is their average.
+type targetChecker struct {
+	targets []ProbeTarget
+	all     bool
+	next    atomic.Uint64
+}
+
+func newTargetChecker(targets []ProbeTarget, mode string) *targetChecker {
+	return &targetChecker{
+		targets: targets,
+		all:     mode == "all",
+	}
+}
+
+func (c *targetChecker) check(ctx context.Context, client *http.Client) (time.Duration, error) {
+	if !c.all {
+		i := c.next.Add(1) - 1
+		return checkTarget(ctx, client, c.targets[i%uint64(len(c.targets))])
+	}
+
+	var total time.Duration
+	for _, target := range c.targets {
+		latency, err := checkTarget(ctx, client, target)
+		if err != nil {
+			return 0, err
+		}
+		total += latency
+	}
+
+	return total / time.Duration(len(c.targets)), nil
+}
+
+func checkTarget(ctx context.Context, client *http.Client, target ProbeTarget) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, target.Method, target.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if target.ExpectedStatus != 0 && resp.StatusCode != target.ExpectedStatus ||
+		target.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return 0, fmt.Errorf("%w %s from %s", errUnexpectedStatus, resp.Status, target.URL)
+	}
+
+	if target.BodyContains != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxTargetBodyBytes))
+		if err != nil {
+			return 0, fmt.Errorf("error reading %s: %w", target.URL, err)
+		}
+		if !strings.Contains(string(body), target.BodyContains) {
+			return 0, fmt.Errorf("%w, %s does not contain %q", errBodyMismatch, target.URL, target.BodyContains)
+		}
+	}
+
+	return time.Since(start), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestTargets serves /ok with 204, /page with a 200 page and /missing
+// with 404, and counts the requests to each path.
+func newTestTargets(t *testing.T) (*httptest.Server, map[string]int) {
+	t.Helper()
+
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusNoContent)
+		case "/page":
+			w.Write([]byte("<html>Welcome to the test page</html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, hits
+}
+
+func TestCheckTarget(t *testing.T) {
+	server, _ := newTestTargets(t)
+
+	tests := []struct {
+		name    string
+		target  ProbeTarget
+		wantErr error
+	}{
+		{"expected status", ProbeTarget{URL: server.URL + "/ok", Method: http.MethodHead, ExpectedStatus: http.StatusNoContent}, nil},
+		{"any 2xx", ProbeTarget{URL: server.URL + "/page", Method: http.MethodHead}, nil},
+		{"body", ProbeTarget{URL: server.URL + "/page", Method: http.MethodGet, ExpectedStatus: http.StatusOK, BodyContains: "test page"}, nil},
+		{"status mismatch", ProbeTarget{URL: server.URL + "/ok", Method: http.MethodHead, ExpectedStatus: http.StatusOK}, errUnexpectedStatus},
+		{"not 2xx", ProbeTarget{URL: server.URL + "/missing", Method: http.MethodHead}, errUnexpectedStatus},
+		{"body mismatch", ProbeTarget{URL: server.URL + "/page", Method: http.MethodGet, BodyContains: "not there"}, errBodyMismatch},
+	}
+
+	for _, tt := range tests {
+		_, err := checkTarget(context.Background(), server.Client(), tt.target)
+		if tt.wantErr == nil && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTargetCheckerRoundRobin(t *testing.T) {
+	server, hits := newTestTargets(t)
+	targets := []ProbeTarget{
+		{URL: server.URL + "/ok", Method: http.MethodHead},
+		{URL: server.URL + "/page", Method: http.MethodGet, BodyContains: "Welcome"},
+	}
+
+	checker := newTargetChecker(targets, "round-robin")
+	for range 4 {
+		if _, err := checker.check(context.Background(), server.Client()); err != nil {
+			t.Fatalf("check: %v", err)
+		}
+	}
+
+	if hits["/ok"] != 2 || hits["/page"] != 2 {
+		t.Errorf("got hits %v, want 2 on each target", hits)
+	}
+}
+
+func TestTargetCheckerAll(t *testing.T) {
+	server, hits := newTestTargets(t)
+	targets := []ProbeTarget{
+		{URL: server.URL + "/ok", Method: http.MethodHead, ExpectedStatus: http.StatusNoContent},
+		{URL: server.URL + "/page", Method: http.MethodGet, BodyContains: "Welcome"},
+	}
+
+	checker := newTargetChecker(targets, "all")
+	if _, err := checker.check(context.Background(), server.Client()); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if hits["/ok"] != 1 || hits["/page"] != 1 {
+		t.Errorf("got hits %v, want 1 on each target", hits)
+	}
+
+	// One failing target fails the whole check.
+	checker = newTargetChecker(append(targets, ProbeTarget{URL: server.URL + "/missing", Method: http.MethodHead}), "all")
+	if _, err := checker.check(context.Background(), server.Client()); !errors.Is(err, errUnexpectedStatus) {
+		t.Errorf("got error %v, want %v", err, errUnexpectedStatus)
+	}
+}
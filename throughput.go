@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultDownloadURL    = "https://speed.cloudflare.com/__down?bytes=25000000"
+	defaultUploadURL      = "https://speed.cloudflare.com/__up"
+	throughputUploadBytes = 10 << 20
+	throughputTimeout     = 15 * time.Second
+)
+
+// Throughput is the result of the throughput stage for one endpoint.
+// Transfers cut off by the timeout still count, up to where they got.
+type Throughput struct {
+	DownloadMbps float64
+	UploadMbps   float64
+	Error        string
+}
+
+// throughputTester measures download and upload speed through an endpoint.
+type throughputTester interface {
+	Throughput(ctx context.Context, endpoint string) Throughput
+	Close() error
+}
+
+func validateThroughputURL(name, value string, required bool) error {
+	if value == "" && !required {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q, it should be an http or https URL", name, value)
+	}
+
+	return nil
+}
+
+// newThroughputTester returns a tester for the given endpoints. Only Xray
+// builds full tunnels, so native scans still need Xray core for this stage.
+func newThroughputTester(endpoints []string) (throughputTester, error) {
+	if err := prepareXrayCore(); err != nil {
+		return nil, fmt.Errorf("throughput test requires Xray core: %w", err)
+	}
+
+	return newXrayProber(endpoints, 0, warpParams)
+}
+
+// testThroughput runs the throughput stage on the first ThroughputCount
+// results one endpoint at a time, so tests do not compete for bandwidth,
+// then scores all results again and ranks them like rankResults does.
+func testThroughput(ctx context.Context, results []ScanResult) error {
+	tested := results[:min(scanConfig.ThroughputCount, len(results))]
+	endpoints := make([]string, 0, len(tested))
+	for _, r := range tested {
+		endpoints = append(endpoints, r.Endpoint)
+	}
+
+	fmt.Printf("\n%s Testing throughput of top %d endpoints...\n\n", prompt, len(tested))
+	tester, err := newThroughputTester(endpoints)
+	if err != nil {
+		return err
+	}
+
+	for i := range tested {
+		throughput := tester.Throughput(ctx, tested[i].Endpoint)
+		tested[i].Throughput = &throughput
+		logThroughput(i, tested[i])
+	}
+
+	if err := tester.Close(); err != nil {
+		return err
+	}
+
+	scoreResults(results)
+	sortResults(results)
+	return nil
+}
+
+func logThroughput(i int, result ScanResult) {
+	t := result.Throughput
+	scanLogger.Info("throughput tested", "endpoint", result.Endpoint, "download", t.DownloadMbps, "upload", t.UploadMbps, "error", t.Error)
+	if t.Error != "" && t.DownloadMbps == 0 {
+		log.Printf("[%d] %s -> %s - %s\n", i+1, fmtStr(result.Endpoint, ORANGE, false), fmtStr("Failed", RED, true), t.Error)
+		return
+	}
+
+	log.Printf("[%d] %s -> %s %.1f Mbps - %s %.1f Mbps\n",
+		i+1,
+		fmtStr(result.Endpoint, ORANGE, false),
+		fmtStr("Download:", "", true),
+		t.DownloadMbps,
+		fmtStr("Upload:", "", true),
+		t.UploadMbps,
+	)
+}
+
+// measureTransfer runs req with client and returns the transfer rate in
+// Mbps. A transfer cut off by the timeout is measured up to that point.
+func measureTransfer(client *http.Client, req *http.Request, uploadBytes int64) (float64, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), throughputTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if uploadBytes > 0 && errors.Is(err, context.DeadlineExceeded) {
+			return 0, fmt.Errorf("upload did not finish within %s", throughputTimeout)
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	transferred, err := io.Copy(io.Discard, resp.Body)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return 0, err
+	}
+	if uploadBytes > 0 {
+		transferred = uploadBytes
+	}
+
+	elapsed := time.Since(start).Seconds()
+	return float64(transferred) * 8 / elapsed / 1e6, nil
+}
+
+// throughputThrough measures download and upload speed with the client,
+// which is expected to go through the endpoint's tunnel.
+func throughputThrough(ctx context.Context, client *http.Client) Throughput {
+	var result Throughput
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scanConfig.ThroughputURL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if result.DownloadMbps, err = measureTransfer(client, req, 0); err != nil {
+		result.Error = fmt.Sprintf("download failed: %v", err)
+		return result
+	}
+
+	if scanConfig.UploadURL == "" {
+		return result
+	}
+
+	body := io.LimitReader(rand.Reader, throughputUploadBytes)
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, scanConfig.UploadURL, body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.ContentLength = throughputUploadBytes
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if result.UploadMbps, err = measureTransfer(client, req, throughputUploadBytes); err != nil {
+		result.Error = fmt.Sprintf("upload failed: %v", err)
+	}
+
+	return result
+}
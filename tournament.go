@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const maxRounds = 10
+
+// parseRounds parses the attempts of each tournament round, like 1,3,10.
+func parseRounds(spec string) ([]int, error) {
+	var rounds []int
+	for part := range strings.SplitSeq(spec, ",") {
+		attempts, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid round attempts %q, expected a number", part)
+		}
+		rounds = append(rounds, attempts)
+	}
+
+	return rounds, nil
+}
+
+func validateRounds(rounds []int, survivorPercent int) error {
+	if len(rounds) > maxRounds {
+		return fmt.Errorf("too many rounds, at most %d are allowed", maxRounds)
+	}
+
+	for _, attempts := range rounds {
+		if isValid, _ := checkNum(strconv.Itoa(attempts), 1, 50); !isValid {
+			return fmt.Errorf("invalid round attempts %d, it should be between 1-50", attempts)
+		}
+	}
+
+	if isValid, _ := checkNum(strconv.Itoa(survivorPercent), 1, 100); !isValid {
+		return fmt.Errorf("invalid survivor percent %d, it should be between 1-100", survivorPercent)
+	}
+
+	return nil
+}
+
+// scanTournament scans the endpoints in rounds. The first round probes every
+// endpoint with a few attempts, each later round probes the best
+// SurvivorPercent of the previous one with more attempts. Attempts add up
+// over rounds, so finalists are ranked on all their attempts.
+func scanTournament(ctx context.Context, limiter *rateLimiter) ([]ScanResult, error) {
+	endpoints := scanConfig.Endpoints
+	merged := make(map[string]ScanResult, len(endpoints))
+
+	for i, attempts := range scanConfig.Rounds {
+		if ctx.Err() != nil || len(endpoints) == 0 {
+			break
+		}
+
+		fmt.Printf("\n%s Round %d of %d: probing %d endpoints %d times...\n\n", prompt, i+1, len(scanConfig.Rounds), len(endpoints), attempts)
+		scanProgress.start(i+1, len(endpoints))
+		results, err := scanBatches(ctx, endpoints, func(string) int { return attempts }, limiter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range results {
+			if prev, ok := merged[r.Endpoint]; ok {
+				r = mergeResults(prev, r)
+			}
+			r.Round = i + 1
+			merged[r.Endpoint] = r
+		}
+		scanLogger.Info("round finished", "round", i+1, "endpoints", len(endpoints), "attempts", attempts)
+
+		contenders := make([]ScanResult, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			if r, ok := merged[endpoint]; ok {
+				contenders = append(contenders, r)
+			}
+		}
+		ranked := rankResults(contenders)
+
+		// Keep enough survivors to fill the results table.
+		survivors := int(math.Ceil(float64(len(endpoints)) * float64(scanConfig.SurvivorPercent) / 100))
+		survivors = min(max(survivors, scanConfig.OutputCount), len(ranked))
+		endpoints = make([]string, 0, survivors)
+		for _, r := range ranked[:survivors] {
+			endpoints = append(endpoints, r.Endpoint)
+		}
+	}
+
+	allResults := make([]ScanResult, 0, len(merged))
+	for _, endpoint := range scanConfig.Endpoints {
+		if r, ok := merged[endpoint]; ok {
+			allResults = append(allResults, r)
+		}
+	}
+
+	return allResults, ctx.Err()
+}
+
+// mergeResults adds the attempts of a later round to the earlier result of
+// the same endpoint and computes its statistics again.
+func mergeResults(prev, next ScanResult) ScanResult {
+	merged := newScanResult(next.Endpoint)
+	merged.StartedAt = prev.StartedAt
+	merged.FinishedAt = next.FinishedAt
+	merged.Attempts = prev.Attempts + next.Attempts
+	merged.Latencies = append(slices.Clone(prev.Latencies), next.Latencies...)
+	merged.Successes = len(merged.Latencies)
+	if merged.Attempts > 0 {
+		merged.Loss = float64(merged.Attempts-merged.Successes) / float64(merged.Attempts) * 100
+	}
+
+	if merged.Successes > 0 {
+		computeLatencyStats(&merged)
+		return merged
+	}
+
+	for _, err := range slices.Concat(prev.Errors, next.Errors) {
+		if !slices.Contains(merged.Errors, err) && len(merged.Errors) < maxEndpointErrors {
+			merged.Errors = append(merged.Errors, err)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// useFakeProber makes every batch of the test scan through prober.
+func useFakeProber(t *testing.T, prober *fakeProber) {
+	t.Helper()
+
+	saved := startProber
+	startProber = func([]string, int, WarpParams) (Prober, error) { return prober, nil }
+	t.Cleanup(func() { startProber = saved })
+}
+
+func TestScanTournament(t *testing.T) {
+	tests := []struct {
+		name        string
+		outputCount int
+		finalists   []string
+	}{
+		// A quarter of 8 endpoints is 2, raised to the 3 rows of the table.
+		{"output count", 3, []string{"10.0.0.1:2408", "10.0.0.2:2408", "10.0.0.3:2408"}},
+		{"survivor percent", 1, []string{"10.0.0.1:2408", "10.0.0.2:2408"}},
+	}
+
+	for _, tt := range tests {
+		config := testScanConfig()
+		config.Engine = "native"
+		config.Rounds = []int{1, 3}
+		config.SurvivorPercent = 25
+		config.OutputCount = tt.outputCount
+		config.Endpoints = nil
+		script := make(map[string][]Attempt)
+		for i := range 8 {
+			endpoint := fmt.Sprintf("10.0.0.%d:2408", i+1)
+			config.Endpoints = append(config.Endpoints, endpoint)
+			if i < 6 {
+				script[endpoint] = []Attempt{succeeded(10 * (i + 1))}
+			}
+		}
+		setScanConfig(t, config)
+
+		// The fastest endpoint of round 1 loses two of its three probes in
+		// round 2.
+		script["10.0.0.1:2408"] = append(script["10.0.0.1:2408"], succeeded(10), failed(errFakeTimeout), failed(errFakeTimeout))
+		for _, endpoint := range tt.finalists[1:] {
+			script[endpoint] = append(script[endpoint], succeeded(50), succeeded(50), succeeded(50))
+		}
+		prober := newFakeProber(script)
+		useFakeProber(t, prober)
+
+		results, err := scanTournament(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("%s: scanTournament: %v", tt.name, err)
+		}
+		if len(results) != len(config.Endpoints) {
+			t.Fatalf("%s: got %d results, want %d", tt.name, len(results), len(config.Endpoints))
+		}
+
+		for _, r := range results {
+			wantAttempts, wantRound := 1, 1
+			if slices.Contains(tt.finalists, r.Endpoint) {
+				wantAttempts, wantRound = 4, 2
+			}
+			if r.Attempts != wantAttempts || prober.calls[r.Endpoint] != wantAttempts || r.Round != wantRound {
+				t.Errorf("%s: %s has %d attempts, %d probes and round %d, want %d, %d and %d",
+					tt.name, r.Endpoint, r.Attempts, prober.calls[r.Endpoint], r.Round, wantAttempts, wantAttempts, wantRound)
+			}
+			if r.Endpoint == "10.0.0.1:2408" && (r.Successes != 2 || r.Loss != 50 || !slices.Equal(r.Latencies, []int64{10, 10})) {
+				t.Errorf("%s: %s merged to %d successes, %g %% loss and latencies %v, want 2, 50 %% and [10 10]",
+					tt.name, r.Endpoint, r.Successes, r.Loss, r.Latencies)
+			}
+		}
+
+		// Finalists rank first, the lossy one below the lossless ones.
+		ranked := rankResults(results)
+		var got []string
+		for _, r := range ranked[:len(tt.finalists)] {
+			got = append(got, r.Endpoint)
+		}
+		want := append(slices.Clone(tt.finalists[1:]), "10.0.0.1:2408")
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got finalists ranked %v, want %v", tt.name, got, want)
+		}
+	}
+}
@@ -1,14 +1,10 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
 
 	"golang.org/x/crypto/curve25519"
 )
@@ -18,25 +14,73 @@ type KeyPair struct {
 	PublicKey  string
 }
 
+type WarpAccount struct {
+	ID          string `json:"id"`
+	AccountType string `json:"account_type"`
+	WarpPlus    bool   `json:"warp_plus"`
+	PremiumData int64  `json:"premium_data"`
+	Quota       int64  `json:"quota"`
+	License     string `json:"license"`
+}
+
 type WarpConfig struct {
-	Config struct {
+	ID      string      `json:"id"`
+	Token   string      `json:"token"`
+	Account WarpAccount `json:"account"`
+	Config  struct {
 		Interface struct {
 			Addresses struct {
+				V4 string `json:"v4"`
 				V6 string `json:"v6"`
 			} `json:"addresses"`
 		} `json:"interface"`
 		ClientID string `json:"client_id"`
 		Peers    []struct {
 			PublicKey string `json:"public_key"`
+			Endpoint  struct {
+				Host  string `json:"host"`
+				V4    string `json:"v4"`
+				V6    string `json:"v6"`
+				Ports []int  `json:"ports"`
+			} `json:"endpoint"`
 		} `json:"peers"`
 	} `json:"config"`
 }
 
 type WarpParams struct {
-	IPv6       string
-	Reserved   []int
-	PublicKey  string
-	PrivateKey string
+	IPv4           string `json:"ipv4,omitempty"`
+	IPv6           string `json:"ipv6"`
+	Reserved       []int  `json:"reserved"`
+	PublicKey      string `json:"publicKey"`
+	PrivateKey     string `json:"privateKey"`
+	PeerHost       string `json:"peerHost,omitempty"`
+	PeerEndpointV4 string `json:"peerEndpointV4,omitempty"`
+	PeerEndpointV6 string `json:"peerEndpointV6,omitempty"`
+	PeerPorts      []int  `json:"peerPorts,omitempty"`
+	ID             string `json:"id,omitempty"`
+	AccountID      string `json:"accountId,omitempty"`
+	Token          string `json:"token,omitempty"`
+	AccountType    string `json:"accountType,omitempty"`
+	License        string `json:"license,omitempty"`
+	Quota          int64  `json:"quota,omitempty"`
+}
+
+// warpParams holds the account used by the last scan, so the best endpoints
+// can be exported with working credentials.
+var warpParams WarpParams
+
+// addresses returns the interface addresses assigned to the account. Accounts
+// stored before the IPv4 address was kept fall back to the Warp default.
+func (p WarpParams) addresses() []string {
+	addresses := []string{"172.16.0.2/32"}
+	if p.IPv4 != "" {
+		addresses[0] = p.IPv4
+	}
+	if p.IPv6 != "" {
+		addresses = append(addresses, p.IPv6)
+	}
+
+	return addresses
 }
 
 func GenerateWireGuardKeyPair() (publicKey, privateKey string, err error) {
@@ -58,54 +102,6 @@ func GenerateWireGuardKeyPair() (publicKey, privateKey string, err error) {
 	return publicKey, privateKey, nil
 }
 
-func fetchWarpConfig(privateKey string) (WarpConfig, error) {
-	payload := map[string]interface{}{
-		"install_id":   "",
-		"fcm_token":    "",
-		"tos":          time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
-		"type":         "Android",
-		"model":        "PC",
-		"locale":       "en_US",
-		"warp_enabled": true,
-		"key":          privateKey,
-	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return WarpConfig{}, fmt.Errorf("error marshaling warp reg payload: %w", err)
-	}
-
-	initHttpClient(false)
-	apiBaseUrl := "https://api.cloudflareclient.com/v0a4005/reg"
-	req, err := http.NewRequest("POST", apiBaseUrl, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return WarpConfig{}, fmt.Errorf("error registering warp: %w", err)
-	}
-	req.Header.Set("User-Agent", "insomnia/8.6.1")
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return WarpConfig{}, fmt.Errorf("error registering warp: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		return WarpConfig{}, fmt.Errorf("HTTP error: %w", err)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return WarpConfig{}, fmt.Errorf("error reading warp config: %w", err)
-	}
-
-	var result WarpConfig
-	if err := json.Unmarshal(body, &result); err != nil {
-		return WarpConfig{}, fmt.Errorf("failed to parse API response: %w", err)
-	}
-
-	return result, nil
-}
-
 func base64ToDecimal(base64Str string) ([]int, error) {
 	decoded, err := base64.StdEncoding.DecodeString(base64Str)
 	if err != nil {
@@ -125,24 +121,50 @@ func extractWarpParams(config WarpConfig, privateKey string) (WarpParams, error)
 		return WarpParams{}, fmt.Errorf("error extracting warp account: %w", err)
 	}
 
-	return WarpParams{
-		IPv6:       config.Config.Interface.Addresses.V6 + "/128",
-		Reserved:   reserved,
-		PublicKey:  config.Config.Peers[0].PublicKey,
-		PrivateKey: privateKey,
-	}, nil
+	if len(config.Config.Peers) == 0 {
+		return WarpParams{}, fmt.Errorf("error extracting warp account: no peers in API response")
+	}
+
+	addresses := config.Config.Interface.Addresses
+	peer := config.Config.Peers[0]
+	params := WarpParams{
+		Reserved:       reserved,
+		PublicKey:      peer.PublicKey,
+		PrivateKey:     privateKey,
+		PeerHost:       peer.Endpoint.Host,
+		PeerEndpointV4: peer.Endpoint.V4,
+		PeerEndpointV6: peer.Endpoint.V6,
+		PeerPorts:      peer.Endpoint.Ports,
+		ID:             config.ID,
+		AccountID:      config.Account.ID,
+		Token:          config.Token,
+		AccountType:    config.Account.AccountType,
+		License:        config.Account.License,
+		Quota:          config.Account.Quota,
+	}
+
+	if addresses.V4 != "" {
+		params.IPv4 = addresses.V4 + "/32"
+	}
+	if addresses.V6 != "" {
+		params.IPv6 = addresses.V6 + "/128"
+	}
+
+	return params, nil
 }
 
-func getWarpParams() (WarpParams, error) {
+func registerWarpAccount() (WarpParams, error) {
 	PublicKey, PrivateKey, err := GenerateWireGuardKeyPair()
 	if err != nil {
 		return WarpParams{}, err
 	}
 
-	config, err := fetchWarpConfig(PublicKey)
+	config, err := defaultWarpRegistrar().Register(context.Background(), PublicKey)
 	if err != nil {
+		warpRegistrations.Inc("failure")
 		return WarpParams{}, err
 	}
+	warpRegistrations.Inc("success")
 	successMessage("Registered a new warp account.\n")
 
 	warpConfig, err := extractWarpParams(config, PrivateKey)
@@ -152,3 +174,27 @@ func getWarpParams() (WarpParams, error) {
 
 	return warpConfig, nil
 }
+
+// applyWarpLicense binds a Warp+ license to a registered account and reads
+// back the resulting account tier and quota.
+func applyWarpLicense(params WarpParams, license string) (WarpParams, error) {
+	if params.ID == "" || params.Token == "" {
+		return WarpParams{}, fmt.Errorf("applying a license needs an account registered by the scanner, imported accounts have no API token")
+	}
+
+	ctx := context.Background()
+	registrar := defaultWarpRegistrar()
+	if err := registrar.UpdateLicense(ctx, params.ID, params.Token, license); err != nil {
+		return WarpParams{}, err
+	}
+
+	account, err := registrar.Account(ctx, params.ID, params.Token)
+	if err != nil {
+		return WarpParams{}, err
+	}
+
+	params.AccountType = account.AccountType
+	params.License = license
+	params.Quota = account.Quota
+	return params, nil
+}
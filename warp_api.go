@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWarpApiUrl   = "https://api.cloudflareclient.com/v0a4005"
+	defaultWarpApiAgent = "insomnia/8.6.1"
+	maxErrorBodyBytes   = 4096
+	maxRetryAfter       = 30 * time.Second
+)
+
+var (
+	ErrRateLimited = errors.New("warp API rate limited")
+	ErrClientError = errors.New("warp API client error")
+	ErrServerError = errors.New("warp API server error")
+)
+
+// APIError is returned for HTTP error responses from the Warp API. It
+// unwraps to ErrRateLimited, ErrClientError or ErrServerError.
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("warp API HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode < 500:
+		return ErrClientError
+	default:
+		return ErrServerError
+	}
+}
+
+// WarpRegistrar registers a WireGuard public key as a new Warp account and
+// manages the license of registered accounts.
+type WarpRegistrar interface {
+	Register(ctx context.Context, publicKey string) (WarpConfig, error)
+	UpdateLicense(ctx context.Context, id, token, license string) error
+	Account(ctx context.Context, id, token string) (WarpAccount, error)
+}
+
+// WarpAPI talks to the Cloudflare client API, or any stand-in serving the
+// same routes under BaseURL.
+type WarpAPI struct {
+	BaseURL   string
+	Client    *http.Client
+	UserAgent string
+	Retries   int
+	Backoff   time.Duration
+}
+
+var warpRegistrar WarpRegistrar
+
+func NewWarpAPI(baseURL string, client *http.Client) *WarpAPI {
+	return &WarpAPI{
+		BaseURL:   baseURL,
+		Client:    client,
+		UserAgent: defaultWarpApiAgent,
+		Retries:   3,
+		Backoff:   time.Second,
+	}
+}
+
+func defaultWarpRegistrar() WarpRegistrar {
+	if warpRegistrar == nil {
+		initHttpClient(false)
+		warpRegistrar = NewWarpAPI(defaultWarpApiUrl, httpClient)
+	}
+
+	return warpRegistrar
+}
+
+func registrationPayload(publicKey string) map[string]any {
+	return map[string]any{
+		"install_id":   "",
+		"fcm_token":    "",
+		"tos":          time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		"type":         "Android",
+		"model":        "PC",
+		"locale":       "en_US",
+		"warp_enabled": true,
+		"key":          publicKey,
+	}
+}
+
+func (api *WarpAPI) Register(ctx context.Context, publicKey string) (WarpConfig, error) {
+	var config WarpConfig
+	if err := api.do(ctx, http.MethodPost, "/reg", "", registrationPayload(publicKey), &config); err != nil {
+		return WarpConfig{}, fmt.Errorf("error registering warp: %w", err)
+	}
+
+	return config, nil
+}
+
+func (api *WarpAPI) UpdateLicense(ctx context.Context, id, token, license string) error {
+	payload := map[string]string{"license": license}
+	if err := api.do(ctx, http.MethodPut, "/reg/"+id+"/account", token, payload, nil); err != nil {
+		return fmt.Errorf("error applying warp license: %w", err)
+	}
+
+	return nil
+}
+
+func (api *WarpAPI) Account(ctx context.Context, id, token string) (WarpAccount, error) {
+	var account WarpAccount
+	if err := api.do(ctx, http.MethodGet, "/reg/"+id+"/account", token, nil, &account); err != nil {
+		return WarpAccount{}, fmt.Errorf("error reading warp account: %w", err)
+	}
+
+	return account, nil
+}
+
+// do sends a JSON request and decodes the response into out. Network
+// errors, rate limits and server errors are retried with exponential backoff,
+// waiting for Retry-After up to maxRetryAfter. Other errors are returned at
+// once, a response that fails to parse may still have created an account.
+func (api *WarpAPI) do(ctx context.Context, method, path, token string, payload, out any) error {
+	var jsonData []byte
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("error marshaling warp payload: %w", err)
+		}
+		jsonData = data
+	}
+
+	var err error
+	for attempt := 0; attempt <= api.Retries; attempt++ {
+		if attempt > 0 {
+			wait := api.Backoff << (attempt - 1)
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+				wait = min(apiErr.RetryAfter, maxRetryAfter)
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		err = api.send(ctx, method, path, token, jsonData, out)
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// isRetryable reports whether a failed request is worth sending again: the
+// request did not get through, or the API asked to try again later.
+func isRetryable(err error) bool {
+	var urlErr *url.Error
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServerError) || errors.As(err, &urlErr)
+}
+
+func (api *WarpAPI) send(ctx context.Context, method, path, token string, jsonData []byte, out any) error {
+	var body io.Reader
+	if jsonData != nil {
+		body = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, api.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", api.UserAgent)
+	if jsonData != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(bytes.TrimSpace(respBody)),
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading warp API response: %w", err)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	return nil
+}
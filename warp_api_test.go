@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestWarpAPI serves the given responses in order, repeating the last one,
+// and returns a client for it with a short backoff and the request counter.
+func newTestWarpAPI(t *testing.T, handlers ...http.HandlerFunc) (*WarpAPI, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		handlers[min(n, len(handlers))-1](w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	api := NewWarpAPI(server.URL, server.Client())
+	api.Backoff = time.Millisecond
+	return api, &calls
+}
+
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+const testRegistration = `{"id":"id-1","token":"token-1","config":{"client_id":"AAAA"}}`
+
+func TestRegisterSuccess(t *testing.T) {
+	api, calls := newTestWarpAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/reg" {
+			t.Errorf("got %s %s, want POST /reg", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("User-Agent"); got != defaultWarpApiAgent {
+			t.Errorf("User-Agent = %q, want %q", got, defaultWarpApiAgent)
+		}
+
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("error decoding payload: %v", err)
+		}
+		if payload["key"] != "public-key" {
+			t.Errorf("payload key = %v, want public-key", payload["key"])
+		}
+
+		w.Write([]byte(testRegistration))
+	})
+
+	config, err := api.Register(context.Background(), "public-key")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if config.ID != "id-1" || config.Token != "token-1" || config.Config.ClientID != "AAAA" {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestRegisterRateLimited(t *testing.T) {
+	api, calls := newTestWarpAPI(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		respond(http.StatusOK, testRegistration),
+	)
+
+	start := time.Now()
+	if _, err := api.Register(context.Background(), "public-key"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("sent %d requests, want 2", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the Retry-After of 1s", elapsed)
+	}
+}
+
+func TestRegisterClientErrorNotRetried(t *testing.T) {
+	api, calls := newTestWarpAPI(t, respond(http.StatusBadRequest, "bad key"))
+
+	_, err := api.Register(context.Background(), "public-key")
+	if !errors.Is(err, ErrClientError) {
+		t.Fatalf("got error %v, want ErrClientError", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestRegisterServerErrorRetried(t *testing.T) {
+	api, calls := newTestWarpAPI(t,
+		respond(http.StatusServiceUnavailable, ""),
+		respond(http.StatusBadGateway, ""),
+		respond(http.StatusOK, testRegistration),
+	)
+
+	config, err := api.Register(context.Background(), "public-key")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if config.ID != "id-1" {
+		t.Errorf("config ID = %q, want id-1", config.ID)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+}
+
+func TestRegisterServerErrorGivesUp(t *testing.T) {
+	api, calls := newTestWarpAPI(t, respond(http.StatusInternalServerError, ""))
+
+	_, err := api.Register(context.Background(), "public-key")
+	if !errors.Is(err, ErrServerError) {
+		t.Fatalf("got error %v, want ErrServerError", err)
+	}
+	if n := calls.Load(); n != int32(api.Retries+1) {
+		t.Errorf("sent %d requests, want %d", n, api.Retries+1)
+	}
+}
+
+func TestRegisterParseErrorNotRetried(t *testing.T) {
+	api, calls := newTestWarpAPI(t, respond(http.StatusOK, "not json"))
+
+	if _, err := api.Register(context.Background(), "public-key"); err == nil {
+		t.Fatal("Register succeeded on a response that is not JSON")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestAPIErrorIncludesBody(t *testing.T) {
+	api, _ := newTestWarpAPI(t, respond(http.StatusForbidden, `{"error":"license is invalid"}`))
+
+	err := api.UpdateLicense(context.Background(), "id-1", "token-1", "license")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v, want an APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusForbidden || apiErr.Body != `{"error":"license is invalid"}` {
+		t.Errorf("unexpected APIError %+v", apiErr)
+	}
+	if !strings.Contains(err.Error(), "license is invalid") {
+		t.Errorf("error %q does not include the response body", err)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// WireGuard handshake constants from the protocol whitepaper.
+const (
+	wgConstruction = "Noise_IKpsk2_25519_ChaChaPoly_BLAKE2s"
+	wgIdentifier   = "WireGuard v1 zx2c4 Jason@zx2c4.com"
+	wgLabelMac1    = "mac1----"
+
+	wgInitiationType = 1
+	wgResponseType   = 2
+	wgCookieType     = 3
+
+	wgInitiationSize = 148
+	wgResponseSize   = 92
+
+	// tai64nBase is 2^62 plus the 10 seconds TAI was ahead of UTC at the Unix
+	// epoch, as wireguard-go encodes timestamps.
+	tai64nBase = uint64(0x400000000000000a)
+)
+
+var errCookieReply = errors.New("peer is under load and replied with a cookie")
+
+// wgKeys holds the decoded keys of a Warp account used for native probes.
+type wgKeys struct {
+	private  [32]byte
+	public   [32]byte
+	peer     [32]byte
+	reserved [3]byte
+}
+
+func decodeWgKey(name, value string) ([32]byte, error) {
+	var key [32]byte
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) != 32 {
+		return key, fmt.Errorf("invalid WireGuard %s, it should be 32 base64 encoded bytes", name)
+	}
+
+	copy(key[:], decoded)
+	return key, nil
+}
+
+func newWgKeys(params WarpParams) (wgKeys, error) {
+	var keys wgKeys
+	var err error
+
+	if keys.private, err = decodeWgKey("private key", params.PrivateKey); err != nil {
+		return wgKeys{}, err
+	}
+	if keys.peer, err = decodeWgKey("peer public key", params.PublicKey); err != nil {
+		return wgKeys{}, err
+	}
+	curve25519.ScalarBaseMult(&keys.public, &keys.private)
+
+	if len(params.Reserved) != 0 && len(params.Reserved) != 3 {
+		return wgKeys{}, fmt.Errorf("reserved should be 3 bytes, got %d", len(params.Reserved))
+	}
+	for i, b := range params.Reserved {
+		keys.reserved[i] = byte(b)
+	}
+
+	return keys, nil
+}
+
+func wgHash(data ...[]byte) [32]byte {
+	h, _ := blake2s.New256(nil)
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func wgMac(key []byte, data []byte) [16]byte {
+	h, _ := blake2s.New128(key)
+	h.Write(data)
+
+	var sum [16]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func wgHmac(key []byte, data ...[]byte) [32]byte {
+	mac := hmac.New(func() hash.Hash {
+		h, _ := blake2s.New256(nil)
+		return h
+	}, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	var sum [32]byte
+	mac.Sum(sum[:0])
+	return sum
+}
+
+// wgKdf derives n keys from the chaining key and input, as KDF1 to KDF3 of
+// the whitepaper.
+func wgKdf(chainKey [32]byte, input []byte, n int) [][32]byte {
+	prk := wgHmac(chainKey[:], input)
+	keys := make([][32]byte, 0, n)
+	var prev []byte
+	for i := 1; i <= n; i++ {
+		key := wgHmac(prk[:], prev, []byte{byte(i)})
+		keys = append(keys, key)
+		prev = key[:]
+	}
+
+	return keys
+}
+
+func wgSeal(key [32]byte, plaintext, additionalData []byte) []byte {
+	aead, _ := chacha20poly1305.New(key[:])
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Seal(nil, nonce, plaintext, additionalData)
+}
+
+func wgOpen(key [32]byte, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(key[:])
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func tai64n(t time.Time) []byte {
+	timestamp := make([]byte, 12)
+	binary.BigEndian.PutUint64(timestamp, tai64nBase+uint64(t.Unix()))
+	binary.BigEndian.PutUint32(timestamp[8:], uint32(t.Nanosecond()))
+	return timestamp
+}
+
+// wgHandshake is the initiator state of a single handshake, kept to verify
+// the responder's reply.
+type wgHandshake struct {
+	keys         wgKeys
+	senderIndex  uint32
+	ephemeral    [32]byte
+	chainKey     [32]byte
+	hash         [32]byte
+	initiationAt time.Time
+}
+
+// newInitiation builds a handshake initiation message. The reserved bytes of
+// the Warp account go into bytes 1-3 of the header, where Cloudflare expects
+// its client id. They are set after MAC1, which covers zero bytes there.
+func newInitiation(keys wgKeys) (*wgHandshake, []byte, error) {
+	hs := &wgHandshake{keys: keys}
+
+	var index [4]byte
+	if _, err := rand.Read(index[:]); err != nil {
+		return nil, nil, fmt.Errorf("error generating sender index: %w", err)
+	}
+	hs.senderIndex = binary.LittleEndian.Uint32(index[:])
+
+	if _, err := rand.Read(hs.ephemeral[:]); err != nil {
+		return nil, nil, fmt.Errorf("error generating ephemeral key: %w", err)
+	}
+	ephemeralPublic, err := curve25519.X25519(hs.ephemeral[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hs.chainKey = wgHash([]byte(wgConstruction))
+	hs.hash = wgHash(hs.chainKey[:], []byte(wgIdentifier))
+	hs.hash = wgHash(hs.hash[:], keys.peer[:])
+
+	msg := make([]byte, wgInitiationSize)
+	msg[0] = wgInitiationType
+	binary.LittleEndian.PutUint32(msg[4:8], hs.senderIndex)
+
+	hs.chainKey = wgKdf(hs.chainKey, ephemeralPublic, 1)[0]
+	copy(msg[8:40], ephemeralPublic)
+	hs.hash = wgHash(hs.hash[:], ephemeralPublic)
+
+	shared, err := curve25519.X25519(hs.ephemeral[:], keys.peer[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid peer public key: %w", err)
+	}
+	derived := wgKdf(hs.chainKey, shared, 2)
+	hs.chainKey = derived[0]
+	encryptedStatic := wgSeal(derived[1], keys.public[:], hs.hash[:])
+	copy(msg[40:88], encryptedStatic)
+	hs.hash = wgHash(hs.hash[:], encryptedStatic)
+
+	shared, err = curve25519.X25519(keys.private[:], keys.peer[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	derived = wgKdf(hs.chainKey, shared, 2)
+	hs.chainKey = derived[0]
+	hs.initiationAt = time.Now()
+	encryptedTimestamp := wgSeal(derived[1], tai64n(hs.initiationAt), hs.hash[:])
+	copy(msg[88:116], encryptedTimestamp)
+	hs.hash = wgHash(hs.hash[:], encryptedTimestamp)
+
+	mac1Key := wgHash([]byte(wgLabelMac1), keys.peer[:])
+	mac1 := wgMac(mac1Key[:], msg[:116])
+	copy(msg[116:132], mac1[:])
+	copy(msg[1:4], keys.reserved[:])
+
+	return hs, msg, nil
+}
+
+// verifyResponse checks that msg is a handshake response to this initiation,
+// by finishing the handshake and opening its empty encrypted payload.
+func (hs *wgHandshake) verifyResponse(msg []byte) error {
+	if len(msg) == 0 {
+		return fmt.Errorf("unexpected empty reply")
+	}
+	if msg[0] == wgCookieType {
+		return errCookieReply
+	}
+	if len(msg) != wgResponseSize || msg[0] != wgResponseType {
+		return fmt.Errorf("unexpected %d byte reply of type %d", len(msg), msg[0])
+	}
+	if binary.LittleEndian.Uint32(msg[8:12]) != hs.senderIndex {
+		return fmt.Errorf("handshake response for another session")
+	}
+
+	mac1Key := wgHash([]byte(wgLabelMac1), hs.keys.public[:])
+	mac1 := wgMac(mac1Key[:], msg[:60])
+	if !hmac.Equal(mac1[:], msg[60:76]) {
+		return fmt.Errorf("invalid handshake response mac")
+	}
+
+	ephemeral := msg[12:44]
+	chainKey := wgKdf(hs.chainKey, ephemeral, 1)[0]
+	h := wgHash(hs.hash[:], ephemeral)
+
+	shared, err := curve25519.X25519(hs.ephemeral[:], ephemeral)
+	if err != nil {
+		return fmt.Errorf("invalid responder ephemeral key: %w", err)
+	}
+	chainKey = wgKdf(chainKey, shared, 1)[0]
+
+	shared, err = curve25519.X25519(hs.keys.private[:], ephemeral)
+	if err != nil {
+		return fmt.Errorf("invalid responder ephemeral key: %w", err)
+	}
+	chainKey = wgKdf(chainKey, shared, 1)[0]
+
+	// Warp peers use no preshared key, which is an all zero key in Noise.
+	var presharedKey [32]byte
+	derived := wgKdf(chainKey, presharedKey[:], 3)
+	h = wgHash(h[:], derived[1][:])
+
+	if _, err := wgOpen(derived[2], msg[44:60], h[:]); err != nil {
+		return fmt.Errorf("handshake response does not match the Warp account keys")
+	}
+
+	return nil
+}
+
+// wgHandshakeRTT sends a handshake initiation to endpoint over UDP, after
+// the noise if any, and returns the time until a valid handshake response
+// arrives.
+func wgHandshakeRTT(ctx context.Context, endpoint string, keys wgKeys, noise *noiseSender, timeout time.Duration) (time.Duration, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := noise.Send(conn); err != nil {
+		return 0, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	hs, msg, err := newInitiation(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(msg); err != nil {
+		return 0, err
+	}
+
+	// Stray or invalid datagrams are skipped until the deadline, the last
+	// verification error explains the failure if nothing valid arrives.
+	var replyErr error
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			if replyErr != nil {
+				return 0, replyErr
+			}
+			if isTimeout(err) {
+				return 0, fmt.Errorf("no handshake response within %s", timeout)
+			}
+			return 0, fmt.Errorf("handshake failed: %w", socketError(err))
+		}
+
+		// Warp echoes the reserved bytes in the reply header, they are not
+		// part of its MAC1 and are zeroed before verifying.
+		if n >= 4 {
+			clear(buf[1:4])
+		}
+		if replyErr = hs.verifyResponse(buf[:n]); replyErr != nil {
+			if errors.Is(replyErr, errCookieReply) {
+				return 0, replyErr
+			}
+			continue
+		}
+
+		return time.Since(start), nil
+	}
+}
+
+// socketError drops the local address from a socket error, it differs on
+// every attempt and would keep identical failures apart.
+func socketError(err error) error {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Err
+	}
+
+	return err
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
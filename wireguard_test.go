@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// testResponder is the responder side of the handshake, built from the same
+// primitives as the initiator, the way a Warp peer answers.
+type testResponder struct {
+	private [32]byte
+	public  [32]byte
+}
+
+func newTestResponder(t *testing.T) *testResponder {
+	t.Helper()
+
+	r := &testResponder{}
+	if _, err := rand.Read(r.private[:]); err != nil {
+		t.Fatal(err)
+	}
+	curve25519.ScalarBaseMult(&r.public, &r.private)
+	return r
+}
+
+// initiation is what the responder learns from a valid initiation.
+type initiation struct {
+	senderIndex uint32
+	ephemeral   []byte
+	static      []byte
+	timestamp   []byte
+	chainKey    [32]byte
+	hash        [32]byte
+}
+
+// consume checks MAC1 of an initiation with bytes 1-3 zeroed and decrypts
+// its static key and timestamp.
+func (r *testResponder) consume(msg []byte) (initiation, error) {
+	if len(msg) != wgInitiationSize || msg[0] != wgInitiationType {
+		return initiation{}, fmt.Errorf("got %d byte message, want a %d byte initiation", len(msg), wgInitiationSize)
+	}
+
+	zeroed := bytes.Clone(msg)
+	clear(zeroed[1:4])
+	mac1Key := wgHash([]byte(wgLabelMac1), r.public[:])
+	if mac1 := wgMac(mac1Key[:], zeroed[:116]); !hmac.Equal(mac1[:], msg[116:132]) {
+		return initiation{}, fmt.Errorf("MAC1 of the initiation does not cover zeroed reserved bytes")
+	}
+
+	var in initiation
+	in.senderIndex = binary.LittleEndian.Uint32(msg[4:8])
+	in.chainKey = wgHash([]byte(wgConstruction))
+	in.hash = wgHash(in.chainKey[:], []byte(wgIdentifier))
+	in.hash = wgHash(in.hash[:], r.public[:])
+
+	in.ephemeral = msg[8:40]
+	in.chainKey = wgKdf(in.chainKey, in.ephemeral, 1)[0]
+	in.hash = wgHash(in.hash[:], in.ephemeral)
+
+	shared, err := curve25519.X25519(r.private[:], in.ephemeral)
+	if err != nil {
+		return initiation{}, err
+	}
+	derived := wgKdf(in.chainKey, shared, 2)
+	in.chainKey = derived[0]
+	if in.static, err = wgOpen(derived[1], msg[40:88], in.hash[:]); err != nil {
+		return initiation{}, fmt.Errorf("error decrypting the initiator static key: %w", err)
+	}
+	in.hash = wgHash(in.hash[:], msg[40:88])
+
+	shared, err = curve25519.X25519(r.private[:], in.static)
+	if err != nil {
+		return initiation{}, err
+	}
+	derived = wgKdf(in.chainKey, shared, 2)
+	in.chainKey = derived[0]
+	if in.timestamp, err = wgOpen(derived[1], msg[88:116], in.hash[:]); err != nil {
+		return initiation{}, fmt.Errorf("error decrypting the timestamp: %w", err)
+	}
+	in.hash = wgHash(in.hash[:], msg[88:116])
+
+	return in, nil
+}
+
+// buildResponse builds the handshake response to an initiation.
+func buildResponse(in initiation) ([]byte, error) {
+	var ephemeral [32]byte
+	if _, err := rand.Read(ephemeral[:]); err != nil {
+		return nil, err
+	}
+	ephemeralPublic, err := curve25519.X25519(ephemeral[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, wgResponseSize)
+	msg[0] = wgResponseType
+	binary.LittleEndian.PutUint32(msg[4:8], 7)
+	binary.LittleEndian.PutUint32(msg[8:12], in.senderIndex)
+
+	chainKey := wgKdf(in.chainKey, ephemeralPublic, 1)[0]
+	copy(msg[12:44], ephemeralPublic)
+	hash := wgHash(in.hash[:], ephemeralPublic)
+
+	for _, public := range [][]byte{in.ephemeral, in.static} {
+		shared, err := curve25519.X25519(ephemeral[:], public)
+		if err != nil {
+			return nil, err
+		}
+		chainKey = wgKdf(chainKey, shared, 1)[0]
+	}
+
+	var presharedKey [32]byte
+	derived := wgKdf(chainKey, presharedKey[:], 3)
+	hash = wgHash(hash[:], derived[1][:])
+	copy(msg[44:60], wgSeal(derived[2], nil, hash[:]))
+
+	mac1Key := wgHash([]byte(wgLabelMac1), in.static)
+	mac1 := wgMac(mac1Key[:], msg[:60])
+	copy(msg[60:76], mac1[:])
+
+	return msg, nil
+}
+
+// handshake starts a handshake of keys with the responder and returns the
+// initiator state, the initiation and what the responder read from it.
+func (r *testResponder) handshake(t *testing.T, keys wgKeys) (*wgHandshake, []byte, initiation) {
+	t.Helper()
+
+	hs, msg, err := newInitiation(keys)
+	if err != nil {
+		t.Fatalf("newInitiation: %v", err)
+	}
+	in, err := r.consume(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hs, msg, in
+}
+
+func mustRespond(t *testing.T, in initiation) []byte {
+	t.Helper()
+
+	msg, err := buildResponse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func newTestKeys(t *testing.T, peer *testResponder, reserved []int) wgKeys {
+	t.Helper()
+
+	var private [32]byte
+	if _, err := rand.Read(private[:]); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := newWgKeys(WarpParams{
+		PrivateKey: base64.StdEncoding.EncodeToString(private[:]),
+		PublicKey:  base64.StdEncoding.EncodeToString(peer.public[:]),
+		Reserved:   reserved,
+	})
+	if err != nil {
+		t.Fatalf("newWgKeys: %v", err)
+	}
+
+	return keys
+}
+
+func TestHandshake(t *testing.T) {
+	responder := newTestResponder(t)
+	keys := newTestKeys(t, responder, []int{1, 2, 3})
+
+	hs, msg, in := responder.handshake(t, keys)
+	if !bytes.Equal(msg[1:4], []byte{1, 2, 3}) {
+		t.Errorf("initiation header has reserved bytes %v, want [1 2 3]", msg[1:4])
+	}
+	if !bytes.Equal(in.static, keys.public[:]) {
+		t.Error("decrypted static key is not the initiator public key")
+	}
+	if len(in.timestamp) != 12 {
+		t.Fatalf("got %d byte timestamp, want 12", len(in.timestamp))
+	}
+	seconds := int64(binary.BigEndian.Uint64(in.timestamp) - 0x400000000000000a)
+	if diff := time.Since(time.Unix(seconds, 0)); diff < -time.Second || diff > time.Minute {
+		t.Errorf("timestamp is %s away from now, want a TAI64N label of the current time", diff)
+	}
+
+	if err := hs.verifyResponse(mustRespond(t, in)); err != nil {
+		t.Errorf("verifyResponse rejected a valid response: %v", err)
+	}
+}
+
+func TestHandshakeRejectsInvalidResponses(t *testing.T) {
+	responder := newTestResponder(t)
+	hs, _, in := responder.handshake(t, newTestKeys(t, responder, nil))
+
+	// A response derived for another account key can not be opened, even
+	// with a MAC1 that passes.
+	other := newTestResponder(t)
+	wrong := in
+	wrong.static = other.public[:]
+	response := mustRespond(t, wrong)
+	mac1Key := wgHash([]byte(wgLabelMac1), hs.keys.public[:])
+	mac1 := wgMac(mac1Key[:], response[:60])
+	copy(response[60:76], mac1[:])
+	if err := hs.verifyResponse(response); err == nil {
+		t.Error("verifyResponse accepted a response for another account key")
+	}
+
+	response = mustRespond(t, in)
+	response[50] ^= 1
+	if err := hs.verifyResponse(response); err == nil {
+		t.Error("verifyResponse accepted a tampered response")
+	}
+
+	if err := hs.verifyResponse(nil); err == nil {
+		t.Error("verifyResponse accepted an empty reply")
+	}
+}
+
+// TestHandshakeRTT runs a handshake over UDP with a responder that first
+// sends an empty datagram, then echoes the reserved bytes like Warp does.
+func TestHandshakeRTT(t *testing.T) {
+	responder := newTestResponder(t)
+	keys := newTestKeys(t, responder, []int{9, 8, 7})
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		in, err := responder.consume(buf[:n])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		response, err := buildResponse(in)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		copy(response[1:4], buf[1:4])
+		conn.WriteToUDP(nil, addr)
+		conn.WriteToUDP(response, addr)
+	}()
+
+	if _, err := wgHandshakeRTT(context.Background(), conn.LocalAddr().String(), keys, nil, 2*time.Second); err != nil {
+		t.Fatalf("wgHandshakeRTT: %v", err)
+	}
+}